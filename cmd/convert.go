@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ghodssyaml "github.com/ghodss/yaml"
+	"github.com/tumblr/k8s-secret-projector/pkg/projector"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// convert rewrites v1 projection mapping files as v2. Converted files are written to stdout,
+// or back over the originals with -in-place. DefaultsFile files are left as they are.
+func convert(args []string) {
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	inPlace := fs.Bool("in-place", false, "Rewrite each file in place, instead of writing to stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-in-place] file...\n", args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	for _, file := range fs.Args() {
+		if filepath.Base(file) == projector.DefaultsFile {
+			log.Printf("Skipping %s: defaults are not a projection mapping, and apply to every apiVersion\n", file)
+			continue
+		}
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Unable to read %s: %s\n", file, err.Error())
+		}
+		converted, err := convertMappingFile(file, raw)
+		if err != nil {
+			log.Fatalf("Unable to convert %s: %s\n", file, err.Error())
+		}
+		if !*inPlace {
+			os.Stdout.Write(converted)
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			log.Fatalf("%s\n", err.Error())
+		}
+		if err := ioutil.WriteFile(file, converted, info.Mode()); err != nil {
+			log.Fatalf("Unable to write %s: %s\n", file, err.Error())
+		}
+		log.Printf("Converted %s\n", file)
+	}
+}
+
+// convertMappingFile converts every document in a projection mapping file to v2. json files
+// stay json, yaml files are re-emitted as a yaml stream; comments are not preserved.
+func convertMappingFile(name string, raw []byte) ([]byte, error) {
+	if strings.HasSuffix(name, ".json") {
+		m, err := convertMappingDocument(name, raw)
+		if err != nil {
+			return nil, err
+		}
+		js, err := marshalJSON(m)
+		if err != nil {
+			return nil, err
+		}
+		return append(js, '\n'), nil
+	}
+	out := bytes.NewBuffer(nil)
+	for i, doc := range projector.SplitYAMLDocuments(raw) {
+		m, err := convertMappingDocument(name, doc)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %s", i+1, err.Error())
+		}
+		y, err := yaml.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(y)
+	}
+	return out.Bytes(), nil
+}
+
+// convertMappingDocument parses a single projection mapping document from the file name as
+// v2, converting it from v1 if needed. A document that may leave out fields it inherits
+// (see projector.IsPartialMapping) is converted as written, keeping extends and removed
+// data items, and is returned as a yaml.MapSlice; any other as a *v2.ProjectionMapping.
+func convertMappingDocument(name string, raw []byte) (interface{}, error) {
+	apiVersion, err := projector.MappingAPIVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch apiVersion {
+	case "", types.APIVersionV1, types.APIVersionV2:
+	default:
+		return nil, fmt.Errorf("unsupported projection mapping apiVersion %s", apiVersion)
+	}
+	m, err := projector.ParseMappingFile(raw)
+	if err != nil {
+		return nil, err
+	}
+	if projector.IsPartialMapping(name, m) {
+		return convertPartialMapping(m), nil
+	}
+	if apiVersion == types.APIVersionV2 {
+		return v2.ParseYamlBytes(raw)
+	}
+	v1m, err := v1.ParseYamlBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	return v2.ConvertFromV1(v1m), nil
+}
+
+// convertPartialMapping converts a projection mapping document parsed with
+// projector.ParseMappingFile to v2. Only the apiVersion and kind differ between v1 and v2
+// documents, so every other field is kept as written, and fields it inherits stay unset.
+func convertPartialMapping(m yaml.MapSlice) yaml.MapSlice {
+	out := yaml.MapSlice{
+		{Key: "apiVersion", Value: types.APIVersionV2},
+		{Key: "kind", Value: types.ProjectionMappingKind},
+	}
+	for _, item := range m {
+		if key := fmt.Sprint(item.Key); key != "apiVersion" && key != "kind" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// marshalJSON returns m as indented json. yaml.MapSlice has no json encoding, so it is
+// converted through yaml.
+func marshalJSON(m interface{}) ([]byte, error) {
+	if _, ok := m.(yaml.MapSlice); !ok {
+		return json.MarshalIndent(m, "", "  ")
+	}
+	y, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	js, err := ghodssyaml.YAMLToJSON(y)
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(nil)
+	if err := json.Indent(out, js, "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
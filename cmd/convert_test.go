@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/projector"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConvertMappingFileExtends(t *testing.T) {
+	raw := []byte(`name: app-secrets
+extends: _base.yaml
+data:
+- name: password
+  remove: true
+- name: token
+  source:
+    raw: "yes"
+`)
+	converted, err := convertMappingFile("app.yaml", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `---
+apiVersion: ` + types.APIVersionV2 + `
+kind: ProjectionMapping
+name: app-secrets
+extends: _base.yaml
+data:
+- name: password
+  remove: true
+- name: token
+  source:
+    raw: "yes"
+`
+	if string(converted) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, string(converted))
+	}
+}
+
+func TestConvertMappingFileTemplate(t *testing.T) {
+	raw := []byte(`repo: team-creds
+namespace: team
+`)
+	converted, err := convertMappingFile("_base.json", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(converted, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, missing := range []string{"name", "data"} {
+		if _, ok := m[missing]; ok {
+			t.Errorf("expected %s to stay unset for the mapping to inherit, got %v", missing, m[missing])
+		}
+	}
+	if m["apiVersion"] != types.APIVersionV2 || m["repo"] != "team-creds" {
+		t.Errorf("unexpected conversion %v", m)
+	}
+}
+
+func TestConvertMappingFileFull(t *testing.T) {
+	raw := []byte(`name: app-secrets
+namespace: team
+repo: team-creds
+data:
+- name: token
+  source:
+    raw: token
+`)
+	converted, err := convertMappingFile("app.yaml", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(converted, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["apiVersion"] != types.APIVersionV2 || m["kind"] != types.ProjectionMappingKind || m["name"] != "app-secrets" {
+		t.Errorf("unexpected conversion %v", m)
+	}
+}
+
+func TestConvertMappingFileInvalidExtends(t *testing.T) {
+	raw := []byte(`extends: _base.yaml
+data:
+- source:
+    raw: token
+`)
+	if _, err := convertMappingFile("app.yaml", raw); err == nil {
+		t.Fatal("expected a data item without a name to be rejected")
+	}
+}
+
+func TestConvertSkipsDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, projector.DefaultsFile)
+	defaults := "repo: team-creds\nnamespace: team\n"
+	if err := ioutil.WriteFile(file, []byte(defaults), 0644); err != nil {
+		t.Fatal(err)
+	}
+	convert([]string{"convert", "-in-place", file})
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != defaults {
+		t.Fatalf("expected %s to be left as it is, got:\n%s", projector.DefaultsFile, string(raw))
+	}
+}
@@ -1,6 +1,7 @@
 package main // import github.com/tumblr/k8s-secret-projector/cmd
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,16 +12,43 @@ import (
 
 	"github.com/tumblr/k8s-secret-projector/internal/pkg/version"
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
 	"github.com/tumblr/k8s-secret-projector/pkg/projector"
 	"github.com/tumblr/k8s-secret-projector/pkg/types"
 	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/printers"
 )
 
+// subcommands are run when named by the first argument, i.e. `k8s-secret-projector report ...`.
+// With no subcommand, the projector projects Secrets from all the mappings.
+var subcommands = map[string]func(args []string){
+	"convert": convert,
+	"modules": modules,
+	"report":  report,
+	"rotate":  rotate,
+	"schema":  schemaCmd,
+}
+
 func main() {
-	c, err := conf.LoadConfigFromArgs(os.Args)
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			cmd(append([]string{fmt.Sprintf("%s %s", os.Args[0], os.Args[1])}, os.Args[2:]...))
+			return
+		}
+	}
+	project(os.Args)
+}
+
+func project(args []string) {
+	c, err := conf.LoadConfigFromArgs(args)
 	if err != nil {
 		log.Fatalf("%s\n", err.Error())
 	}
+	projectMappings(c)
+}
+
+// projectMappings projects Secrets from all the mappings, and writes them out
+func projectMappings(c conf.Config) {
 	log.Printf("%s version=%s commit=%s branch=%s runtime=%s built=%s", version.Package, version.Version, version.Commit, version.Branch, runtime.Version(), version.BuildDate)
 	if c.Debug() {
 		for repo, path := range c.CredsRootPaths() {
@@ -29,6 +57,11 @@ func main() {
 		log.Printf("projection mappings path: %s\n", c.ProjectionMappingsRootPath())
 	}
 	app := projector.New(c)
+	defer func() {
+		if err := app.Close(); err != nil {
+			log.Printf("Unable to shut down: %s\n", err.Error())
+		}
+	}()
 
 	projectionMappings, err := app.LoadProjectionMappings()
 	if err != nil {
@@ -39,32 +72,51 @@ func main() {
 	}
 	log.Printf("Loaded %d projection mappings\n", len(projectionMappings))
 
-	var secrets []k8sv1.Secret
+	if len(c.ChangedCredsFiles()) > 0 || len(c.ChangedCredsRevisions()) > 0 {
+		projectionMappings, err = app.FilterChangedProjectionMappings(projectionMappings)
+		if err != nil {
+			log.Fatalf("Unable to determine projection mappings affected by creds changes: %s\n", err.Error())
+		}
+		log.Printf("%d projection mappings are affected by creds changes\n", len(projectionMappings))
+		for _, m := range projectionMappings {
+			log.Printf("Affected projection mapping: %s\n", m.String())
+		}
+		if len(projectionMappings) == 0 {
+			return
+		}
+	}
+
+	var secrets []*k8sv1.Secret
+	failed := 0
 	for _, m := range projectionMappings {
-		credsRepoPath := getCredsRepo(c, m)
+		credsRepo := getCredsRepo(c, m)
 		if c.Debug() {
 			log.Printf("Projecting mapping file: %s\n", m.String())
 		}
-		k8sSecret, err := m.ProjectSecret(credsRepoPath)
+		// a mapping may fan out to a Secret in each of many namespaces
+		k8sSecrets, err := m.ProjectSecrets(credsRepo)
 		if err != nil {
 			log.Printf("Unable to project %s into a Kubernetes Secret: %s\n", m.String(), err.Error())
 			// we will bail out later, dont worry!
+			failed++
 			continue
 		}
-		secrets = append(secrets, *k8sSecret)
+		secrets = append(secrets, k8sSecrets...)
 		if c.Debug() {
-			log.Printf("Generated Secret for %s:\n", k8sSecret.String())
-			yamlString, err := m.ProjectSecretAsYAMLString(credsRepoPath)
-			if err != nil {
-				log.Fatal(err.Error())
+			for _, k8sSecret := range k8sSecrets {
+				log.Printf("Generated Secret for %s:\n", k8sSecret.String())
+				yamlString, err := secretAsYAMLString(k8sSecret)
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+				log.Print(yamlString)
 			}
-			log.Printf(yamlString)
 		}
 	}
 
 	// fail if we were unable to generate any secret projections
-	if len(secrets) != len(projectionMappings) {
-		log.Fatalf("Expected we would create %d Secrets, but only successfully created %d\n", len(projectionMappings), len(secrets))
+	if failed > 0 {
+		log.Fatalf("Expected we would project %d mappings into Secrets, but %d failed\n", len(projectionMappings), failed)
 	}
 
 	if c.OutputDir() != "" {
@@ -81,16 +133,14 @@ func main() {
 			log.Fatalf("error: output %s is not a directory\n", c.OutputDir())
 		}
 
-		for _, m := range projectionMappings {
-			// skip secrets we were told to not write out
-			credsRepoPath := getCredsRepo(c, m)
-			yamlString, err := m.ProjectSecretAsYAMLString(credsRepoPath)
+		for _, sec := range secrets {
+			yamlString, err := secretAsYAMLString(sec)
 			if err != nil {
-				log.Fatalf("unable to project mapping %s: %s", m.String(), err.Error())
+				log.Fatalf("unable to marshal Secret %s/%s: %s", sec.Namespace, sec.Name, err.Error())
 			}
-			fname := filepath.Join(c.OutputDir(), fmt.Sprintf("%d-%s-%s.yaml", tUnix, m.GetNamespace(), m.GetName()))
+			fname := filepath.Join(c.OutputDir(), fmt.Sprintf("%d-%s-%s.yaml", tUnix, sec.Namespace, sec.Name))
 
-			log.Printf("writing %s/%s Secret to %s...\n", m.GetNamespace(), m.GetName(), fname)
+			log.Printf("writing %s/%s Secret to %s...\n", sec.Namespace, sec.Name, fname)
 			err = ioutil.WriteFile(fname, []byte(yamlString), 0400)
 			if err != nil {
 				log.Fatalf("unable to write Secret to %s: %s", fname, err.Error())
@@ -101,9 +151,8 @@ func main() {
 
 	if c.Debug() && c.ShowSecrets() {
 		log.Printf("Secrets:\n")
-		for _, m := range projectionMappings {
-			credsRepoPath := getCredsRepo(c, m)
-			yamlString, err := m.ProjectSecretAsYAMLString(credsRepoPath)
+		for _, sec := range secrets {
+			yamlString, err := secretAsYAMLString(sec)
 			if err != nil {
 				log.Fatal(err.Error())
 			}
@@ -112,11 +161,25 @@ func main() {
 	}
 }
 
-func getCredsRepo(c conf.Config, m types.ProjectionMapping) string {
+// secretAsYAMLString returns the YAML representation of a projected Secret
+func secretAsYAMLString(sec *k8sv1.Secret) (string, error) {
+	p := printers.YAMLPrinter{}
+	buf := bytes.NewBuffer([]byte{})
+	if err := p.PrintObj(sec, buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func getCredsRepo(c conf.Config, m types.ProjectionMapping) creds.FS {
 	credsRepoPath, err := c.CredsRootPath(m.GetRepo())
 	if err != nil {
-		log.Fatalf("Unsupported repo type %s for projection mapping %s/%s (perhaps you missed a --creds-repo=%s=/path/to/repo argument)\n", m.GetRepo(), m.GetNamespace(), m.GetName(), m.GetRepo())
+		log.Fatalf("Unsupported repo type %s for projection mapping %s (perhaps you missed a --creds-repo=%s=/path/to/repo argument)\n", m.GetRepo(), m.String(), m.GetRepo())
+	}
+	credsRepo, err := creds.Open(credsRepoPath)
+	if err != nil {
+		log.Fatalf("Unable to open creds repo %s at %s: %s\n", m.GetRepo(), credsRepoPath, err.Error())
 	}
 
-	return credsRepoPath
+	return credsRepo
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
+)
+
+// modules lists the registered encryption modules and the params they accept
+func modules(args []string) {
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	fs.Parse(args[1:])
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, name := range encryption.List() {
+		r, _ := encryption.Lookup(name)
+		fmt.Fprintf(w, "%s\n", name)
+		if r.Params == nil {
+			fmt.Fprintf(w, "  (any params)\n")
+			continue
+		}
+		if len(r.Params) == 0 {
+			fmt.Fprintf(w, "  (no params)\n")
+			continue
+		}
+		params := make([]string, 0, len(r.Params))
+		for param := range r.Params {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+		for _, param := range params {
+			fmt.Fprintf(w, "  %s\t%s\n", param, describeParam(r.Params[param]))
+		}
+	}
+	w.Flush()
+}
+
+// describeParam describes a param of an encryption module in a single line
+func describeParam(p encryption.Param) string {
+	desc := []string{}
+	if p.Description != "" {
+		desc = append(desc, p.Description)
+	}
+	if p.Required {
+		desc = append(desc, "required")
+	}
+	if p.Default != "" {
+		desc = append(desc, "default "+p.Default)
+	}
+	if len(p.Values) > 0 {
+		desc = append(desc, "one of "+strings.Join(p.Values, ", "))
+	}
+	if p.Pattern != "" {
+		desc = append(desc, "matching "+p.Pattern)
+	}
+	return strings.Join(desc, "; ")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/projector"
+	reportpkg "github.com/tumblr/k8s-secret-projector/pkg/report"
+)
+
+// report writes a reverse index of which namespaces/Secrets consume which creds files,
+// without reading any secret values
+func report(args []string) {
+	var format, reportFile string
+	c, err := conf.LoadConfigFromArgsWithFlags(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&format, "format", "json", "Report format (json or csv)")
+		fs.StringVar(&reportFile, "report-file", "", "Write the report here, instead of stdout")
+	})
+	if err != nil {
+		log.Fatalf("%s\n", err.Error())
+	}
+
+	projectionMappings, err := projector.New(c).LoadProjectionMappings()
+	if err != nil {
+		log.Fatalf("Unable to load projection mappings: %s\n", err.Error())
+	}
+	r := reportpkg.NewAccessReport(projectionMappings)
+
+	var out io.Writer = os.Stdout
+	if reportFile != "" {
+		f, err := os.Create(reportFile)
+		if err != nil {
+			log.Fatalf("Unable to create report file %s: %s\n", reportFile, err.Error())
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch format {
+	case "json":
+		err = r.WriteJSON(out)
+	case "csv":
+		err = r.WriteCSV(out)
+	default:
+		log.Fatalf("Unsupported report format %s\n", format)
+	}
+	if err != nil {
+		log.Fatalf("Unable to write report: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+)
+
+// rotate adds a new active key to the keyring in the -creds-encryption-key file,
+// optionally retiring old keys, and then re-projects all the mappings with it
+func rotate(args []string) {
+	var keyID, retire string
+	c, err := conf.LoadConfigFromArgsWithFlags(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&keyID, "key-id", "", "ID of the new key (defaults to the current UTC time)")
+		fs.StringVar(&retire, "retire", "", "Comma separated IDs of keys to retire")
+	})
+	if err != nil {
+		log.Fatalf("%s\n", err.Error())
+	}
+	path := c.CredsEncryptionKeyFile()
+	if path == "" {
+		log.Fatal("rotate requires a -creds-encryption-key keyring\n")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Unable to open keyring %s: %s\n", path, err.Error())
+	}
+	kr, err := cbc.ReadKeyring(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Unable to read keyring %s: %s\n", path, err.Error())
+	}
+	k, err := kr.Rotate(keyID)
+	if err != nil {
+		log.Fatalf("Unable to rotate keyring %s: %s\n", path, err.Error())
+	}
+	for _, id := range strings.Split(retire, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if err := kr.Retire(id); err != nil {
+			log.Fatalf("Unable to retire key %s: %s\n", id, err.Error())
+		}
+	}
+	if err := writeKeyring(path, kr); err != nil {
+		log.Fatalf("Unable to write keyring %s: %s\n", path, err.Error())
+	}
+	log.Printf("Rotated keyring %s to new active key %s\n", path, k.ID)
+
+	projectMappings(c)
+}
+
+// writeKeyring replaces the keyring at path, keeping its file mode. The keyring is written
+// to a temporary file that is renamed over path, so a failed write does not lose any keys
+func writeKeyring(path string, kr *cbc.Keyring) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := kr.Write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
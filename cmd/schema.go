@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/schema"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+)
+
+// schemaCmd writes the JSON Schema of projection mapping files to stdout, for editors and linters
+func schemaCmd(args []string) {
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	apiVersion := fs.String("api-version", types.APIVersionV1, "apiVersion of the projection mappings to describe")
+	fs.Parse(args[1:])
+
+	s, err := schema.ProjectionMappingFile(*apiVersion)
+	if err != nil {
+		log.Fatalf("%s\n", err.Error())
+	}
+	js, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Fatalf("Unable to marshal schema: %s\n", err.Error())
+	}
+	os.Stdout.Write(append(js, '\n'))
+}
@@ -0,0 +1,42 @@
+package testing
+
+import (
+	"testing"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+)
+
+var (
+	// SomeData is the plaintext encryption modules are tested with
+	SomeData = "hello, this is a secret"
+	// Context1 and Context2 are the same Secret and key, projected into different namespaces
+	Context1 = aad.Context{Namespace: "web", Name: "db-credentials", Key: "password"}
+	Context2 = aad.Context{Namespace: "api", Name: "db-credentials", Key: "password"}
+)
+
+// Crypter is the part of an encryption module RoundTrip exercises
+type Crypter interface {
+	Encrypt([]byte, aad.Context) ([]byte, error)
+	Decrypt([]byte, aad.Context) ([]byte, error)
+}
+
+// RoundTrip encrypts SomeData for Context1 with enc, and checks dec decrypts it there, but
+// not in Context2, as ciphertext is bound to where it is projected. It returns the ciphertext.
+func RoundTrip(t *testing.T, enc Crypter, dec Crypter) []byte {
+	t.Helper()
+	ciphertext, err := enc.Encrypt([]byte(SomeData), Context1)
+	if err != nil {
+		t.Fatalf("error encrypting data: %s", err.Error())
+	}
+	plaintext, err := dec.Decrypt(ciphertext, Context1)
+	if err != nil {
+		t.Fatalf("error decrypting data: %s", err.Error())
+	}
+	if string(plaintext) != SomeData {
+		t.Errorf("decrypted data '%s' should have been '%s'", plaintext, SomeData)
+	}
+	if _, err := dec.Decrypt(ciphertext, Context2); err == nil {
+		t.Errorf("data encrypted for %s should not decrypt in %s", Context1, Context2)
+	}
+	return ciphertext
+}
@@ -0,0 +1,57 @@
+package testing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// GitRepo creates a git repository, committing the files of each of commits in turn, keyed
+// by path. It returns the path of the repository, for the caller to remove. The test is
+// skipped if git is not installed.
+func GitRepo(t *testing.T, commits ...map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Git(t, dir, "init", "-q")
+	for i, files := range commits {
+		for name, content := range files {
+			WriteFile(t, dir, name, content)
+		}
+		Git(t, dir, "add", "-A")
+		Git(t, dir, "commit", "-q", "-m", fmt.Sprintf("commit %d", i+1))
+	}
+	return dir
+}
+
+// Git runs git in the repository at dir, failing the test if it fails, and returns its trimmed output
+func Git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %s: %s", args, err.Error(), out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// WriteFile writes content to the file name in dir, making its parent directories
+func WriteFile(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
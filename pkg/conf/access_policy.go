@@ -0,0 +1,105 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AccessPolicy limits which creds files a namespace is allowed to project. A
+// reference is allowed if any rule matches it; everything else is denied.
+type AccessPolicy struct {
+	Rules []AccessRule `yaml:"rules"`
+}
+
+// AccessRule grants namespaces matching any of Namespaces access to the files matching
+// any of Paths, in any of the creds repos matching Repos. All fields accept globs (see
+// path.Match); Paths additionally accept ** to match any number of directories.
+type AccessRule struct {
+	Namespaces []string `yaml:"namespaces"`
+	Repos      []string `yaml:"repos"`
+	Paths      []string `yaml:"paths"`
+}
+
+// LoadAccessPolicyFromFile reads an AccessPolicy from a yaml file
+func LoadAccessPolicyFromFile(f string) (*AccessPolicy, error) {
+	raw, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	p := AccessPolicy{}
+	err = yaml.UnmarshalStrict(raw, &p)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse access policy %s: %s", f, err.Error())
+	}
+	if err = p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid access policy %s: %s", f, err.Error())
+	}
+	return &p, nil
+}
+
+// Validate makes sure all the globs in the policy are well formed
+func (p *AccessPolicy) Validate() error {
+	for i, r := range p.Rules {
+		if len(r.Namespaces) == 0 || len(r.Repos) == 0 || len(r.Paths) == 0 {
+			return fmt.Errorf("rule %d must specify namespaces, repos and paths", i)
+		}
+		for _, g := range append(append(append([]string{}, r.Namespaces...), r.Repos...), r.Paths...) {
+			if _, err := path.Match(g, ""); err != nil {
+				return fmt.Errorf("rule %d has a malformed glob %s: %s", i, g, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Allows returns true if namespace may project file (relative to the creds repo) from repo
+func (p *AccessPolicy) Allows(namespace string, repo string, file string) bool {
+	file = path.Clean(filepath.ToSlash(file))
+	for _, r := range p.Rules {
+		if matchAny(r.Namespaces, namespace, path.Match) && matchAny(r.Repos, repo, path.Match) && matchAny(r.Paths, file, matchPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(globs []string, s string, match func(string, string) (bool, error)) bool {
+	for _, g := range globs {
+		if ok, _ := match(g, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath is path.Match, but allows a ** path element to match zero or more directories
+func matchPath(pattern string, name string) (bool, error) {
+	return matchPathElements(strings.Split(path.Clean(pattern), "/"), strings.Split(name, "/"))
+}
+
+func matchPathElements(pattern []string, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchPathElements(pattern[1:], name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if !ok || err != nil {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
@@ -0,0 +1,50 @@
+package conf
+
+import (
+	"testing"
+
+	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+)
+
+const (
+	testAccessPolicy = "test/fixtures/policies/access_policy_1.yaml"
+)
+
+func TestAccessPolicyAllows(t *testing.T) {
+	p, err := LoadAccessPolicyFromFile(testAccessPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		namespace string
+		repo      string
+		path      string
+		allowed   bool
+	}{
+		{"json-tests", "production", "object1.json", true},
+		{"yaml-tests", "production", "./object1.yaml", true},
+		{"json-tests", "staging", "object1.json", false},
+		{"json-tests", "production", "nested/object1.json", false},
+		{"other", "production", "object1.json", false},
+		{"raw-test1", "staging", "raw1.txt", true},
+		{"raw-test1", "production", "a/b/c/raw1.txt", true},
+		{"raw-test1", "production", "raw1.json", false},
+	}
+	for _, tst := range tests {
+		if a := p.Allows(tst.namespace, tst.repo, tst.path); a != tst.allowed {
+			t.Errorf("expected %s reading %s:%s to be allowed=%t but got %t", tst.namespace, tst.repo, tst.path, tst.allowed, a)
+		}
+	}
+}
+
+func TestAccessPolicyValidate(t *testing.T) {
+	bad := []AccessPolicy{
+		{Rules: []AccessRule{{Namespaces: []string{"foo"}, Repos: []string{"production"}}}},
+		{Rules: []AccessRule{{Namespaces: []string{"[foo"}, Repos: []string{"production"}, Paths: []string{"*"}}}},
+	}
+	for _, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected policy %v to be invalid", p)
+		}
+	}
+}
@@ -3,12 +3,15 @@ package conf
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tumblr/k8s-secret-projector/internal/pkg/version"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
 )
 
 type resourceType int
@@ -31,6 +34,17 @@ type config struct {
 	credsEncryptionKeyFile string
 	// credsKeyEncryptionKeys path to credential keys encryption key
 	credsKeyDecryptionKeyFile string
+	// accessPolicyFile path to the policy limiting which namespaces may use which creds files
+	accessPolicyFile string
+	accessPolicy     *AccessPolicy
+	// changedCredsFiles limits projection to mappings using these files, keyed by creds repo
+	changedCredsFiles map[string][]string
+	// changedCredsRevisions limits projection to mappings using files changed between rev1..rev2, keyed by creds repo
+	changedCredsRevisions map[string]string
+	// knownNamespaces are the namespaces that namespace globs in projection mappings are matched against
+	knownNamespaces     []string
+	knownNamespacesList string
+	knownNamespacesFile string
 
 	// Label all generated ConfigMaps with this key, using the value of --generation
 	labelVersionKey string
@@ -38,6 +52,10 @@ type config struct {
 	labelManagedKey string
 	// Generation label used when annotating secrets
 	labelSecretGeneration string
+	// Annotate all generated Secrets with where each data item was projected from
+	addProvenanceAnnotations bool
+	// Annotation key used to record provenance
+	annotationProvenanceKey string
 }
 
 // Config is the interface for loading flag settings for the CLI app
@@ -47,6 +65,10 @@ type Config interface {
 	CredsEncryptionKeyFile() string
 	CredsKeyDecryptionKeyFile() string
 	ProjectionMappingsRootPath() string
+	AccessPolicy() *AccessPolicy
+	ChangedCredsFiles() map[string][]string
+	ChangedCredsRevisions() map[string]string
+	KnownNamespaces() []string
 	OutputDir() string
 	Debug() bool
 	ShowSecrets() bool
@@ -56,10 +78,18 @@ type Config interface {
 	LabelVersionKey() string
 	LabelManagedKey() string
 	AddDeployLabels() bool
+	AddProvenanceAnnotations() bool
+	AnnotationProvenanceKey() string
 }
 
 // LoadConfigFromArgs returns a new config given some CLI args
 func LoadConfigFromArgs(args []string) (Config, error) {
+	return LoadConfigFromArgsWithFlags(args, nil)
+}
+
+// LoadConfigFromArgsWithFlags returns a new config given some CLI args, allowing the
+// caller to register additional flags (i.e. for a subcommand) before parsing
+func LoadConfigFromArgsWithFlags(args []string, extraFlags func(*flag.FlagSet)) (Config, error) {
 	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
 	c := config{}
 	fs.Usage = func() {
@@ -67,20 +97,33 @@ func LoadConfigFromArgs(args []string) (Config, error) {
 		fs.PrintDefaults()
 	}
 	credsRepoFlags := NewMapStringStringFlag()
+	changedCredsFlags := NewMapStringSliceFlag()
+	changedCredsRevisionFlags := NewMapStringStringFlag()
 
 	fs.BoolVar(&c.showSecrets, "debug-show-secrets", true, "Show generated secrets YAML contents (only if -debug)")
 	fs.BoolVar(&c.debug, "debug", false, "Debug")
 	fs.StringVar(&c.outputDir, "output", "", "Output generated secrets here")
 
-	fs.Var(&credsRepoFlags, "creds-repo", "label=<path> pair identifying a source credentials repository (i.e. production=/path/to/repo/production) (required)")
+	fs.Var(&credsRepoFlags, "creds-repo", "label=<path> pair identifying a source credentials repository (i.e. production=/path/to/repo/production), label=<path>@<ref> to read from a git revision (i.e. production=/path/to/repo.git@refs/heads/master), or label=<path> to a .tar, .tar.gz or .zip archive (required)")
+
+	fs.Var(&changedCredsFlags, "changed-creds", "label=<path> pair identifying a changed file in a creds repo; only mappings using changed files are projected (optional, repeatable)")
+	fs.Var(&changedCredsRevisionFlags, "changed-creds-revs", "label=<rev1>..<rev2> pair; only mappings using files changed between two git revisions of a creds repo are projected (optional)")
 
 	fs.StringVar(&c.credsEncryptionKeyFile, "creds-encryption-key", "", "path to load creds_keys.json from creds_internal (optional, depends on your encryption modules in use)")
-	fs.StringVar(&c.credsKeyDecryptionKeyFile, "creds-key-decryption-key", "", "path to load decryption keys from (optional, depends on your encryption modules in use)")
-	fs.StringVar(&c.mappingsRootPath, "manifests", "", "Path to projection mapping yamls (required)")
+	fs.StringVar(&c.credsKeyDecryptionKeyFile, "creds-key-decryption-key", "", "path to load the key-encryption key that wraps included decryption keys from (optional, depends on your encryption modules in use)")
+	fs.StringVar(&c.mappingsRootPath, "manifests", "", "Path to projection mapping yamls; a directory, a .tar, .tar.gz or .zip archive, or - to read a multi-document yaml stream from stdin (required)")
+	fs.StringVar(&c.accessPolicyFile, "access-policy", "", "Path to a policy yaml limiting which namespaces may project which creds files (optional)")
+	fs.StringVar(&c.knownNamespacesList, "known-namespaces", "", "Comma separated namespaces that namespace globs in projection mappings are matched against (optional)")
+	fs.StringVar(&c.knownNamespacesFile, "known-namespaces-file", "", "File of namespaces, one per line (i.e. the output of kubectl get namespaces -o name), that namespace globs in projection mappings are matched against (optional)")
 	fs.BoolVar(&c.addDeployLabels, "label-secrets", true, "Label secrets generated with --label-version-key and --label-managed-key")
 	fs.StringVar(&c.labelSecretGeneration, "generation", strconv.FormatInt(time.Now().Unix(), 10), "Generation label used when annotating Secrets. See --label-version-key")
 	fs.StringVar(&c.labelManagedKey, "label-managed-key", "tumblr.com/managed-secret", "Label all generated Secrets with this key=true")
 	fs.StringVar(&c.labelVersionKey, "label-version-key", "tumblr.com/secret-version", "Label all generated Secrets with this key, using the value of --generation")
+	fs.BoolVar(&c.addProvenanceAnnotations, "annotate-provenance", false, "Annotate secrets generated with the creds repo, path, jsonpath and last commit each data item was projected from, using --annotation-provenance-key")
+	fs.StringVar(&c.annotationProvenanceKey, "annotation-provenance-key", "tumblr.com/secret-provenance", "Annotation key used to record the provenance of generated Secrets. See --annotate-provenance")
+	if extraFlags != nil {
+		extraFlags(fs)
+	}
 
 	err := fs.Parse(args[1:])
 	if err != nil {
@@ -88,29 +131,93 @@ func LoadConfigFromArgs(args []string) (Config, error) {
 	}
 
 	c.credsRootPaths = credsRepoFlags.ToMapStringString()
+	c.changedCredsFiles = changedCredsFlags.ToMapStringSlice()
+	c.changedCredsRevisions = changedCredsRevisionFlags.ToMapStringString()
 
 	err = c.Validate()
+	if err != nil {
+		return &c, err
+	}
+	if c.knownNamespaces, err = loadKnownNamespaces(c.knownNamespacesList, c.knownNamespacesFile); err != nil {
+		return &c, err
+	}
+	if c.accessPolicyFile != "" {
+		c.accessPolicy, err = LoadAccessPolicyFromFile(c.accessPolicyFile)
+	}
 	return &c, err
 }
 
-func (c *config) Validate() (err error) {
-	requiredDirs := map[string]string{
-		"manifests": c.mappingsRootPath,
+// loadKnownNamespaces combines the comma separated namespaces in list with the namespaces in
+// file, one per line. Blank lines, # comments and kubectl's namespace/ prefix are ignored.
+func loadKnownNamespaces(list string, file string) ([]string, error) {
+	namespaces := []string{}
+	for _, ns := range strings.Split(list, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
 	}
+	if file == "" {
+		return namespaces, nil
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known-namespaces-file %s: %s", file, err.Error())
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "namespace/")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			namespaces = append(namespaces, line)
+		}
+	}
+	return namespaces, nil
+}
+
+func (c *config) Validate() (err error) {
+	requiredDirs := map[string]string{}
 	requiredFiles := map[string]string{}
+	switch {
+	case c.mappingsRootPath == "-":
+		// read from stdin
+	case creds.IsArchive(c.mappingsRootPath):
+		requiredFiles["manifests"] = c.mappingsRootPath
+	default:
+		requiredDirs["manifests"] = c.mappingsRootPath
+	}
 	optionalFiles := map[string]string{
 		"creds-encryption-key":     c.credsEncryptionKeyFile,
 		"creds-key-decryption-key": c.credsKeyDecryptionKeyFile,
+		"access-policy":            c.accessPolicyFile,
+		"known-namespaces-file":    c.knownNamespacesFile,
 	}
 
 	if len(c.credsRootPaths) == 0 {
 		return fmt.Errorf("at least 1 --creds-repo argument is required")
 	}
 	for identifier, path := range c.credsRootPaths {
+		if creds.IsRevisionSpec(path) || creds.IsArchive(path) {
+			// a git repository and revision, or an archive, to read creds from rather than a checkout
+			if _, err = creds.Open(path); err != nil {
+				return fmt.Errorf("unable to open creds-repo %s argument %s: %s", identifier, path, err.Error())
+			}
+			continue
+		}
 		if err = validateKeyedResource("creds-repo", identifier, path, directory); err != nil {
 			return err
 		}
 	}
+	for identifier := range c.changedCredsFiles {
+		if _, ok := c.credsRootPaths[identifier]; !ok {
+			return fmt.Errorf("changed-creds references creds repo %s, but no --creds-repo=%s=... was given", identifier, identifier)
+		}
+	}
+	for identifier, revs := range c.changedCredsRevisions {
+		if _, ok := c.credsRootPaths[identifier]; !ok {
+			return fmt.Errorf("changed-creds-revs references creds repo %s, but no --creds-repo=%s=... was given", identifier, identifier)
+		}
+		if r := strings.Split(revs, ".."); len(r) != 2 || r[0] == "" || r[1] == "" {
+			return fmt.Errorf("changed-creds-revs %s argument %s should be formatted as rev1..rev2", identifier, revs)
+		}
+	}
 	for flag, value := range optionalFiles {
 		if value != "" {
 			if err := validateResource(flag, value, file); err != nil {
@@ -226,6 +333,22 @@ func (c *config) ProjectionMappingsRootPath() string {
 	return c.mappingsRootPath
 }
 
+func (c *config) AccessPolicy() *AccessPolicy {
+	return c.accessPolicy
+}
+
+func (c *config) ChangedCredsFiles() map[string][]string {
+	return c.changedCredsFiles
+}
+
+func (c *config) ChangedCredsRevisions() map[string]string {
+	return c.changedCredsRevisions
+}
+
+func (c *config) KnownNamespaces() []string {
+	return c.knownNamespaces
+}
+
 func (c *config) Debug() bool {
 	return c.debug
 }
@@ -261,3 +384,11 @@ func (c *config) Generation() string {
 func (c *config) AddDeployLabels() bool {
 	return c.addDeployLabels
 }
+
+func (c *config) AddProvenanceAnnotations() bool {
+	return c.addProvenanceAnnotations
+}
+
+func (c *config) AnnotationProvenanceKey() string {
+	return c.annotationProvenanceKey
+}
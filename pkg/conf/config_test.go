@@ -2,7 +2,9 @@ package conf
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -102,3 +104,27 @@ func TestConfigGeneration(t *testing.T) {
 	}
 
 }
+
+func TestKnownNamespaces(t *testing.T) {
+	f, err := ioutil.TempFile("", "namespaces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, "namespace/web-1\n\n# comment\nweb-2\n")
+	f.Close()
+
+	c, err := LoadConfigFromArgs(mapToArgs(map[string]string{
+		"creds-repo":            fmt.Sprintf("%s=%s", "production", testFolder),
+		"manifests":             testFolder,
+		"known-namespaces":      "api, db",
+		"known-namespaces-file": f.Name(),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"api", "db", "web-1", "web-2"}
+	if !reflect.DeepEqual(c.KnownNamespaces(), expected) {
+		t.Errorf("expected known namespaces %v, got %v", expected, c.KnownNamespaces())
+	}
+}
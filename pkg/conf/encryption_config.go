@@ -2,14 +2,20 @@ package conf
 
 // Encryption defines how a projection mapping wants to encrypt its keys
 type Encryption struct {
-	Module                string `yaml:"module",json:"module"`
-	IncludeDecryptionKeys bool   `yaml:"include_decryption_keys",json:"include_decryption_keys"`
-	// PluginPath is the path to the .so on the filesystem, if this Module is loaded from a shared object, and Module: "plugin"
-	PluginPath string `yaml:"plugin-path",json:"plugin-path"`
+	Module                string `yaml:"module" json:"module"`
+	IncludeDecryptionKeys bool   `yaml:"include_decryption_keys,omitempty" json:"include_decryption_keys,omitempty"`
+	// PluginPath is the path to the executable of the plugin, if Module: "plugin". It runs as a child
+	// process speaking JSON-RPC over its stdin and stdout
+	PluginPath string `yaml:"plugin-path,omitempty" json:"plugin-path,omitempty"`
+	// Format selects how ciphertext is encoded: "envelope" (the default), "armor" for an ASCII armored
+	// envelope, or "raw" for the legacy bare ciphertext without a header
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+	// Recipients are PEM encoded public keys that public key modules encrypt to
+	Recipients []string `yaml:"recipients,omitempty" json:"recipients,omitempty"`
 	// Options are arbitrary flags available to underlying implementations
-	Params map[string]string `yaml:"params",json:"params"`
+	Params map[string]string `yaml:"params,omitempty" json:"params,omitempty"`
 	// CredsKeysFilePath tends to not be specified in a projection mapping; this is merged from the CLI flags
-	CredsKeysFilePath string `yaml:"creds_keys_file",json:"creds_keys_file"`
+	CredsKeysFilePath string `yaml:"creds_keys_file,omitempty" json:"creds_keys_file,omitempty"`
 	// KeysDecrypterFilePath tends to not be specified in a projection mapping; this is merged from the CLI flags
-	KeysDecrypterFilePath string `yaml:"keys_decrypter_file",json:"keys_decrypter_file"`
+	KeysDecrypterFilePath string `yaml:"keys_decrypter_file,omitempty" json:"keys_decrypter_file,omitempty"`
 }
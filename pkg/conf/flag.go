@@ -43,3 +43,44 @@ func (s *MapStringStringFlag) ToMapStringString() map[string]string {
 func NewMapStringStringFlag() MapStringStringFlag {
 	return MapStringStringFlag{Values: map[string]string{}}
 }
+
+// MapStringSliceFlag is a flag struct for key=value pairs, where a key may be repeated
+type MapStringSliceFlag struct {
+	Values map[string][]string
+}
+
+// String implements the flag.Var interface
+func (s *MapStringSliceFlag) String() string {
+	z := []string{}
+	for x, ys := range s.Values {
+		for _, y := range ys {
+			z = append(z, fmt.Sprintf("%s=%s", x, y))
+		}
+	}
+	return strings.Join(z, ",")
+}
+
+// Set implements the flag.Var interface
+func (s *MapStringSliceFlag) Set(value string) error {
+	if s.Values == nil {
+		s.Values = map[string][]string{}
+	}
+	for _, p := range strings.Split(value, ",") {
+		fields := strings.Split(p, "=")
+		if len(fields) != 2 {
+			return fmt.Errorf("%s is incorrectly formatted! should be identifier=value[,id2=value2]", p)
+		}
+		s.Values[fields[0]] = append(s.Values[fields[0]], fields[1])
+	}
+	return nil
+}
+
+// ToMapStringSlice returns the underlying representation of the map of key=value pairs
+func (s *MapStringSliceFlag) ToMapStringSlice() map[string][]string {
+	return s.Values
+}
+
+// NewMapStringSliceFlag creates a new flag var for storing key=value pairs with repeated keys
+func NewMapStringSliceFlag() MapStringSliceFlag {
+	return MapStringSliceFlag{Values: map[string][]string{}}
+}
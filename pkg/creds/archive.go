@@ -0,0 +1,115 @@
+package creds
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// IsArchive returns true if path names a tarball (.tar, .tar.gz, .tgz) or zip (.zip)
+// archive of a creds repo, rather than a directory
+func IsArchive(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenArchive reads the tarball or zip archive at path into a MemFS
+func OpenArchive(path string) (MemFS, error) {
+	if strings.HasSuffix(path, ".zip") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		s, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return NewZipFS(f, s.Size())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewTarFS(f)
+}
+
+// NewTarFS reads a (optionally gzipped) tarball of a creds repo into a MemFS. Only
+// regular files are kept; symlinks and other special files are skipped.
+func NewTarFS(r io.Reader) (MemFS, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	m := MemFS{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read tarball: %s", err.Error())
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := ValidPath(h.Name); err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from tarball: %s", h.Name, err.Error())
+		}
+		m[cleanPath(h.Name)] = data
+	}
+	return m, nil
+}
+
+// NewZipFS reads a zip archive of a creds repo into a MemFS. Only regular files are
+// kept; symlinks and other special files are skipped.
+func NewZipFS(r io.ReaderAt, size int64) (MemFS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read zip archive: %s", err.Error())
+	}
+	m := MemFS{}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if err := ValidPath(f.Name); err != nil {
+			return nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("unable to open %s in zip archive: %s", f.Name, err.Error())
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from zip archive: %s", f.Name, err.Error())
+		}
+		m[cleanPath(f.Name)] = data
+	}
+	return m, nil
+}
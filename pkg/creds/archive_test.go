@@ -0,0 +1,102 @@
+package creds
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"testing"
+)
+
+var (
+	testFiles = map[string]string{
+		"object1.json":      `{"secret":"paSsw0rd!"}`,
+		"./nested/raw1.txt": "hello\n",
+	}
+)
+
+func testArchiveFS(t *testing.T, m MemFS) {
+	for name, expected := range map[string]string{
+		"object1.json":      `{"secret":"paSsw0rd!"}`,
+		"nested/raw1.txt":   "hello\n",
+		"./nested/raw1.txt": "hello\n",
+	} {
+		data, err := m.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected %s to be %s, but got %s", name, expected, data)
+		}
+	}
+	if _, err := m.ReadFile("link.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected symlinks to be skipped, but got %v", err)
+	}
+	if _, err := m.ReadFile("../object1.json"); err == nil {
+		t.Error("expected reading ../object1.json to fail")
+	}
+}
+
+func TestTarFS(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		buf := bytes.NewBuffer(nil)
+		var tw *tar.Writer
+		var gz *gzip.Writer
+		if gzipped {
+			gz = gzip.NewWriter(buf)
+			tw = tar.NewWriter(gz)
+		} else {
+			tw = tar.NewWriter(buf)
+		}
+		for name, content := range testFiles {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: "link.txt", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+		if gz != nil {
+			gz.Close()
+		}
+
+		m, err := NewTarFS(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testArchiveFS(t, m)
+	}
+}
+
+func TestZipFS(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	for name, content := range testFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &zip.FileHeader{Name: "link.txt"}
+	h.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("/etc/passwd"))
+	zw.Close()
+
+	m, err := NewZipFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testArchiveFS(t, m)
+}
@@ -0,0 +1,85 @@
+package creds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is a creds repo checked out in a directory on disk. Reads are confined to the
+// directory, so symlinks that resolve outside of it are refused.
+type Dir string
+
+// NewDir returns a Dir for the directory at path
+func NewDir(path string) (Dir, error) {
+	s, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !s.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+	return Dir(path), nil
+}
+
+// ReadFile reads the named file from the directory
+func (d Dir) ReadFile(name string) ([]byte, error) {
+	p, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
+}
+
+// resolve joins name onto the directory, making sure the result (after following
+// any symlinks) is still inside the directory. This keeps a projection mapping from
+// reading files it was not granted by way of ../ or a symlink out of the repo.
+func (d Dir) resolve(name string) (string, error) {
+	if err := ValidPath(name); err != nil {
+		return "", err
+	}
+	joined := filepath.Join(string(d), name)
+	root, err := filepath.EvalSymlinks(string(d))
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(joined)
+	if os.IsNotExist(err) {
+		// let the caller report the missing file when it tries to read it
+		return joined, nil
+	} else if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %s", name, ErrPathOutsideRepo)
+	}
+	return resolved, nil
+}
+
+func (d Dir) String() string {
+	return string(d)
+}
+
+// LastCommit returns the SHA of the last commit that changed the named file, or an
+// empty string if it was never committed. Outside of a git work tree (or without git
+// installed), it returns ErrNotInGit.
+func (d Dir) LastCommit(name string) (string, error) {
+	if err := ValidPath(name); err != nil {
+		return "", err
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", ErrNotInGit
+	}
+	if out, err := git(string(d), "rev-parse", "--is-inside-work-tree"); err != nil || strings.TrimSpace(string(out)) != "true" {
+		return "", ErrNotInGit
+	}
+	out, err := git(string(d), "log", "-1", "--format=%H", "--", name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
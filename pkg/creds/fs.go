@@ -0,0 +1,97 @@
+package creds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrAbsolutePath is returned when a file is referenced by an absolute path, instead of a path relative to the creds repo
+	ErrAbsolutePath = errors.New("source path must be relative to the creds repo, not absolute")
+	// ErrPathOutsideRepo is returned when a path (or a symlink it traverses) resolves outside of the creds repo
+	ErrPathOutsideRepo = errors.New("source path resolves outside of the creds repo")
+	// ErrNotInGit is returned by LastCommit when the creds repo is not in git, so there is no commit to report
+	ErrNotInGit = errors.New("creds repo is not in a git work tree")
+
+	opened     = map[string]FS{}
+	openedLock sync.Mutex
+)
+
+// FS is a read-only view of the files in a creds repository, in the style of
+// io/fs.ReadFileFS. Implementations are provided for directories on disk (Dir), git
+// revisions (GitRevision), memory (MemFS), and tar or zip archives (NewTarFS, NewZipFS).
+type FS interface {
+	// ReadFile returns the contents of the named file. The name is relative to the root
+	// of the creds repository, and may not leave it.
+	ReadFile(name string) ([]byte, error)
+	String() string
+}
+
+// Open returns the FS for a creds repo, as passed to --creds-repo. This is either a
+// directory, a tar or zip archive (see IsArchive), or a local git repository and revision
+// to read files from without a checkout, formatted as /path/to/repo.git@<ref> (see IsRevisionSpec).
+// FSs are cached by spec, so a git ref is resolved to a commit only once per run.
+func Open(spec string) (FS, error) {
+	openedLock.Lock()
+	defer openedLock.Unlock()
+	if fsys, ok := opened[spec]; ok {
+		return fsys, nil
+	}
+	var fsys FS
+	var err error
+	if repo, rev, ok := SplitRevisionSpec(spec); ok {
+		fsys, err = NewGitRevision(repo, rev)
+	} else if IsArchive(spec) {
+		fsys, err = OpenArchive(spec)
+	} else {
+		fsys, err = NewDir(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	opened[spec] = fsys
+	return fsys, nil
+}
+
+// IsRevisionSpec returns true if spec refers to a revision of a git repository
+// (/path/to/repo.git@<ref>), rather than a directory
+func IsRevisionSpec(spec string) bool {
+	_, _, ok := SplitRevisionSpec(spec)
+	return ok
+}
+
+// SplitRevisionSpec splits /path/to/repo.git@<ref> into the repository path and ref.
+// An existing directory is never treated as a revision spec, even if it contains an @.
+func SplitRevisionSpec(spec string) (repo string, rev string, ok bool) {
+	i := strings.LastIndex(spec, "@")
+	if i <= 0 || i == len(spec)-1 {
+		return "", "", false
+	}
+	if s, err := os.Stat(spec); err == nil && s.IsDir() {
+		return "", "", false
+	}
+	return spec[:i], spec[i+1:], true
+}
+
+// ValidPath checks that name is relative, and does not climb out of the repo root
+func ValidPath(name string) error {
+	if filepath.IsAbs(name) {
+		return fmt.Errorf("%s: %s", name, ErrAbsolutePath)
+	}
+	if c := filepath.Clean(name); c == ".." || strings.HasPrefix(c, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s: %s", name, ErrPathOutsideRepo)
+	}
+	return nil
+}
+
+// Revisioner is implemented by FSs backed by git, that can tell which commit last changed a file
+type Revisioner interface {
+	// LastCommit returns the SHA of the last commit that changed the named file, or an
+	// empty string if it was never committed. It returns ErrNotInGit if the FS turns
+	// out not to be backed by git
+	LastCommit(name string) (string, error)
+}
@@ -0,0 +1,111 @@
+package creds
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrSymlinkInRevision is returned when reading a file from a git revision that is a symlink
+	ErrSymlinkInRevision = errors.New("symlinks are not supported when reading creds from a git revision")
+)
+
+// GitRevision reads creds straight out of the object store of a local git repository
+// (bare, or with a work tree) at a single commit, so no plaintext checkout is needed
+type GitRevision struct {
+	repo string
+	rev  string
+	// commit is the SHA rev resolved to, when the GitRevision was created
+	commit string
+}
+
+// NewGitRevision returns a GitRevision for rev (any ref or commit-ish) in the git repository at repo
+func NewGitRevision(repo string, rev string) (*GitRevision, error) {
+	out, err := git(repo, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve %s in git repository %s: %s", rev, repo, err.Error())
+	}
+	return &GitRevision{
+		repo:   repo,
+		rev:    rev,
+		commit: strings.TrimSpace(string(out)),
+	}, nil
+}
+
+// ReadFile reads the named file from the commit
+func (g *GitRevision) ReadFile(name string) ([]byte, error) {
+	if err := ValidPath(name); err != nil {
+		return nil, err
+	}
+	p := path.Clean(filepath.ToSlash(name))
+	out, err := git(g.repo, "ls-tree", "-z", g.commit, "--", p)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: g.describe(name), Err: err}
+	}
+	// <mode> SP <type> SP <object> TAB <file>
+	fields := strings.Fields(strings.SplitN(string(out), "\t", 2)[0])
+	if len(fields) != 3 {
+		return nil, &os.PathError{Op: "open", Path: g.describe(name), Err: os.ErrNotExist}
+	}
+	if fields[0] == "120000" {
+		return nil, &os.PathError{Op: "open", Path: g.describe(name), Err: ErrSymlinkInRevision}
+	}
+	if fields[1] != "blob" {
+		return nil, &os.PathError{Op: "open", Path: g.describe(name), Err: fmt.Errorf("is a %s, not a file", fields[1])}
+	}
+	data, err := git(g.repo, "cat-file", "blob", fields[2])
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: g.describe(name), Err: err}
+	}
+	return data, nil
+}
+
+// LastCommit returns the SHA of the last commit (at or before the revision) that changed the named file
+func (g *GitRevision) LastCommit(name string) (string, error) {
+	if err := ValidPath(name); err != nil {
+		return "", err
+	}
+	out, err := git(g.repo, "log", "-1", "--format=%H", g.commit, "--", path.Clean(filepath.ToSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Repo returns the path of the git repository
+func (g *GitRevision) Repo() string {
+	return g.repo
+}
+
+// Commit returns the SHA of the commit files are read from
+func (g *GitRevision) Commit() string {
+	return g.commit
+}
+
+func (g *GitRevision) describe(name string) string {
+	return fmt.Sprintf("%s@%s:%s", g.repo, g.rev, name)
+}
+
+func (g *GitRevision) String() string {
+	return fmt.Sprintf("%s@%s (%s)", g.repo, g.rev, g.commit)
+}
+
+func git(repo string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], err.Error())
+	}
+	return out, nil
+}
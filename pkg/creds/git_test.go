@@ -0,0 +1,149 @@
+package creds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testutil "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+)
+
+// newTestRepo creates a git repository with two commits, the first tagged first, returning its path
+func newTestRepo(t *testing.T) string {
+	dir := testutil.GitRepo(t, map[string]string{"nested/secret.txt": "first"})
+	testutil.Git(t, dir, "tag", "first")
+	testutil.WriteFile(t, dir, "nested/secret.txt", "second")
+	if err := os.Symlink("nested/secret.txt", filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Git(t, dir, "add", "-A")
+	testutil.Git(t, dir, "commit", "-q", "-m", "second")
+	return dir
+}
+
+func TestGitRevisionReadFile(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		"first": "first",
+		"HEAD":  "second",
+	}
+	for rev, expected := range tests {
+		g, err := Open(dir + "@" + rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := g.ReadFile("nested/secret.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected nested/secret.txt at %s to be %s, but got %s", rev, expected, data)
+		}
+	}
+
+	g, err := NewGitRevision(dir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.ReadFile("doesnt-exist.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected reading a missing file to fail with a not exist error, but got %v", err)
+	}
+	if _, err = g.ReadFile("link.txt"); err == nil || !strings.Contains(err.Error(), ErrSymlinkInRevision.Error()) {
+		t.Errorf("expected reading a symlink to fail with %s, but got %v", ErrSymlinkInRevision, err)
+	}
+	if _, err = g.ReadFile("nested"); err == nil {
+		t.Error("expected reading a directory to fail")
+	}
+	if _, err = g.ReadFile("../secret.txt"); err == nil {
+		t.Error("expected reading ../secret.txt to fail")
+	}
+	if _, err = NewGitRevision(dir, "doesnt-exist"); err == nil {
+		t.Error("expected opening a missing revision to fail")
+	}
+}
+
+func TestSplitRevisionSpec(t *testing.T) {
+	repo, rev, ok := SplitRevisionSpec("/repos/creds.git@refs/heads/master")
+	if !ok || repo != "/repos/creds.git" || rev != "refs/heads/master" {
+		t.Errorf("expected /repos/creds.git@refs/heads/master to split, but got %s %s %t", repo, rev, ok)
+	}
+	for _, spec := range []string{"/repos/creds", "/repos/creds@", "@HEAD"} {
+		if IsRevisionSpec(spec) {
+			t.Errorf("expected %s to not be a revision spec", spec)
+		}
+	}
+}
+
+func TestGitRevisionLastCommit(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		"first": testutil.Git(t, dir, "rev-parse", "first"),
+		"HEAD":  testutil.Git(t, dir, "rev-parse", "HEAD"),
+	}
+	for rev, expected := range tests {
+		g, err := NewGitRevision(dir, rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit, err := g.LastCommit("nested/secret.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit != expected {
+			t.Errorf("expected nested/secret.txt at %s to be last changed in %s, but got %s", rev, expected, commit)
+		}
+	}
+	g, err := NewGitRevision(dir, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// link.txt was added after the revision
+	if commit, err := g.LastCommit("link.txt"); err != nil || commit != "" {
+		t.Errorf("expected no commit for a file added after the revision, but got %s (%v)", commit, err)
+	}
+	if _, err := g.LastCommit("../secret.txt"); err == nil {
+		t.Error("expected the last commit of ../secret.txt to fail")
+	}
+}
+
+func TestDirLastCommit(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := d.LastCommit("nested/secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := testutil.Git(t, dir, "rev-parse", "HEAD"); commit != expected {
+		t.Errorf("expected nested/secret.txt to be last changed in %s, but got %s", expected, commit)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "untracked.txt"), []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if commit, err := d.LastCommit("untracked.txt"); err != nil || commit != "" {
+		t.Errorf("expected no commit for an untracked file, but got %s (%v)", commit, err)
+	}
+
+	outside, err := ioutil.TempDir("", "creds-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	d, err = NewDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.LastCommit("secret.txt"); err != ErrNotInGit {
+		t.Errorf("expected %v outside of a git work tree, but got %v", ErrNotInGit, err)
+	}
+}
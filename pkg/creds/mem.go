@@ -0,0 +1,32 @@
+package creds
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// MemFS is a creds repo held in memory, mapping slash separated paths (relative to the
+// root of the repo) to file contents
+type MemFS map[string][]byte
+
+// ReadFile returns the contents of the named file
+func (m MemFS) ReadFile(name string) ([]byte, error) {
+	if err := ValidPath(name); err != nil {
+		return nil, err
+	}
+	data, ok := m[cleanPath(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (m MemFS) String() string {
+	return "memory"
+}
+
+// cleanPath normalizes a path relative to the root of a repo, so ./a//b and a/b are the same file
+func cleanPath(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))[1:]
+}
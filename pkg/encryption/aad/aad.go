@@ -0,0 +1,23 @@
+package aad
+
+import (
+	"fmt"
+)
+
+// Context is where a data item is projected to. Encryption modules bind ciphertext
+// to it as associated data, so it only decrypts in the Secret and key it was projected for
+type Context struct {
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// Bytes returns the associated data for the Context, formatted as namespace/name/key.
+// Namespaces, Secret names and data keys can not contain a /, so this is unambiguous
+func (c Context) Bytes() []byte {
+	return []byte(c.String())
+}
+
+func (c Context) String() string {
+	return fmt.Sprintf("%s/%s/%s", c.Namespace, c.Name, c.Key)
+}
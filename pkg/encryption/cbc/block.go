@@ -8,29 +8,60 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
-	"encoding/json"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
 	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
 )
 
+// ModuleName selects the cbc module in `$.encryption.module`. Envelopes sealed directly with
+// a key of the keyring record it, along with the key ID and kdf params
+const ModuleName = "cbc"
+
+var (
+	// ErrCiphertextTooShort is returned when decrypting data shorter than a nonce
+	ErrCiphertextTooShort = errors.New("ciphertext too short")
+)
+
 // Crypter is the module responsible for performing CBC encryption/decryption for secrets
 // It uses cipher.Block as its underlying implementation
 type Crypter struct {
-	c     conf.Encryption
-	block cipher.Block
-	gcm   cipher.AEAD
-	k     *Key
+	c conf.Encryption
+	// gcm encrypts with the active key k
+	gcm cipher.AEAD
+	k   *Key
+	// keys are all the keys that are not retired, including k
+	keys []*Key
+	// fileKeys are keys as read from the key file, in the order of keys
+	fileKeys []*Key
+	// headerKeys are keys derived with the kdf params of envelope headers that differ
+	// from the configured ones, keyed by headerKeysKey
+	headerKeys   map[string][]*Key
+	headerKeysMu sync.Mutex
+	// kek wraps the DecryptionKeys, if there is a key-encryption key
+	kek *Crypter
 }
 
-// New creates a new Crypter encryption module
-func New(c conf.Encryption, credsKeyReader io.Reader) (*Crypter, error) {
+// New creates a new Crypter encryption module. If keysDecrypterReader has a key file,
+// it is the key-encryption key that DecryptionKeys are wrapped with, which is required
+// to include the decryption keys in Secrets
+func New(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (*Crypter, error) {
 	m := Crypter{
 		c: c,
 	}
+	if err := envelope.ValidFormat(c.Format); err != nil {
+		return nil, err
+	}
 
 	// set defaults if omitted, so we pick appropriate hashers for the cipher
 	if c.Params["cipher"] == "" {
@@ -43,77 +74,280 @@ func New(c conf.Encryption, credsKeyReader io.Reader) (*Crypter, error) {
 			c.Params["hash"] = "md5"
 		}
 	}
+	if c.Params["kdf"] == "" {
+		c.Params["kdf"] = KDFLegacy
+	}
+	kdf, err := kdfFromParams(c.Params)
+	if err != nil {
+		return nil, err
+	}
 
-	var hasher hash.Hash
-	switch c.Params["hash"] {
-	case "":
-		fallthrough
-	case "sha1":
-		hasher = sha1.New()
-	case "sha256":
-		hasher = sha256.New()
-	case "sha512":
-		hasher = sha512.New()
-	case "md5":
-		hasher = md5.New()
-	default:
-		return nil, fmt.Errorf("unsupported hash %s", c.Params["hash"])
+	if _, err := hasherFor(c.Params["hash"]); err != nil {
+		return nil, err
 	}
 
-	// read in the key, and deserialize it. We only care about the Password
-	// field, cause we want to call the constructor with that and our hasher
-	tmpKey := Key{}
-	err := json.NewDecoder(credsKeyReader).Decode(&tmpKey)
+	// read in the key file, a single key or a keyring. We only care about the
+	// Password and Salt fields, cause we want to derive the cipher keys from them
+	kr, err := ReadKeyring(credsKeyReader)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load key: %s", err.Error())
 	}
-	key := NewKey(c.Params["cipher"], hasher, tmpKey.Password)
+	ids := map[string]bool{}
+	for _, fileKey := range kr.Keys {
+		if fileKey.Retired {
+			continue
+		}
+		key, err := deriveKey(c.Params["cipher"], kdf, fileKey.Salt, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate key id %s in keyring", key.ID)
+		}
+		ids[key.ID] = true
+		m.fileKeys = append(m.fileKeys, fileKey)
+		m.keys = append(m.keys, key)
+		if key.Active {
+			m.k = key
+			m.gcm = key.aead
+		}
+	}
+	if m.k == nil {
+		return nil, ErrNoActiveKey
+	}
+
+	m.kek, err = newKEK(c, keysDecrypterReader)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create key: %s", err.Error())
+		return nil, fmt.Errorf("unable to load key-encryption key: %s", err.Error())
 	}
-	m.k = key
+	if c.IncludeDecryptionKeys && m.kek == nil {
+		return nil, ErrIncludeKeysWithoutKEK
+	}
+	return &m, nil
+}
 
-	switch c.Params["cipher"] {
-	case "":
-		fallthrough
-	case "aes":
-		m.block, err = aes.NewCipher([]byte(m.k.paddedHashedPassword))
+// Decrypt some bytes found in ctx, either sealed in an envelope, or legacy raw ciphertext.
+// Envelopes are opened with the key their header names, derived with the kdf params of the
+// header, and other data with any key that opens it
+func (c *Crypter) Decrypt(data []byte, ctx aad.Context) (plaintext []byte, err error) {
+	nonceSize := c.gcm.NonceSize()
+	h, ciphertext, err := envelope.Unmarshal(data)
+	switch err {
+	case nil:
+		if h.Module != ModuleName {
+			return nil, fmt.Errorf("envelope was sealed by encryption module %s, not %s", h.Module, ModuleName)
+		}
+		keys, err := c.keysFor(h)
 		if err != nil {
 			return nil, err
 		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("envelope was sealed with key %s, which is not in the keyring", h.KeyID)
+		}
+		if nonceSize := keys[0].aead.NonceSize(); len(h.Nonce) != nonceSize {
+			return nil, fmt.Errorf("envelope nonce is %d bytes, expected %d", len(h.Nonce), nonceSize)
+		}
+		// envelopes sealed before binding to a context have no associated data
+		var additionalData []byte
+		if h.Bound {
+			additionalData = ctx.Bytes()
+		}
+		for _, k := range keys {
+			if k.ID == h.KeyID {
+				return k.aead.Open(nil, h.Nonce, ciphertext, additionalData)
+			}
+		}
+		// the key may predate its ID, i.e. a single key file that became a keyring
+		if plaintext, err = open(keys, h.Nonce, ciphertext, additionalData); err != nil {
+			return nil, fmt.Errorf("envelope was sealed with key %s, which is not in the keyring", h.KeyID)
+		}
+		return plaintext, nil
+	case envelope.ErrNotEnvelope:
+		if len(data) < nonceSize {
+			return nil, ErrCiphertextTooShort
+		}
+		return open(c.keys, data[:nonceSize], data[nonceSize:], nil)
 	default:
-		return nil, fmt.Errorf("unsupported cipher %s", c.Params["cipher"])
+		return nil, err
 	}
+}
 
-	m.gcm, err = cipher.NewGCM(m.block)
+// keysFor returns the keys to open an envelope with: the configured keys if the key the
+// header names was derived with the header's params, or else every key derived anew with
+// them, so envelopes sealed with another kdf, cost or cipher still open
+func (c *Crypter) keysFor(h *envelope.Header) ([]*Key, error) {
+	for _, k := range c.keys {
+		if k.ID == h.KeyID && reflect.DeepEqual(c.headerParams(k), h.Params) {
+			return c.keys, nil
+		}
+	}
+	cacheKey := headerKeysKey(h.Params)
+	c.headerKeysMu.Lock()
+	defer c.headerKeysMu.Unlock()
+	if keys, ok := c.headerKeys[cacheKey]; ok {
+		return keys, nil
+	}
+	kdf, err := kdfFromParams(h.Params)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("envelope kdf: %s", err.Error())
 	}
-	return &m, nil
+	var salt []byte
+	if h.Params["salt"] != "" {
+		if salt, err = base64.StdEncoding.DecodeString(h.Params["salt"]); err != nil {
+			return nil, fmt.Errorf("envelope salt: %s", err.Error())
+		}
+	}
+	keys := make([]*Key, 0, len(c.fileKeys))
+	for _, fileKey := range c.fileKeys {
+		keySalt := salt
+		if keySalt == nil {
+			keySalt = fileKey.Salt
+		}
+		key, err := deriveKey(h.Params["cipher"], kdf, keySalt, fileKey)
+		if err != nil {
+			return nil, fmt.Errorf("envelope was sealed with params this key can not derive a key with: %s", err.Error())
+		}
+		keys = append(keys, key)
+	}
+	if c.headerKeys == nil {
+		c.headerKeys = map[string][]*Key{}
+	}
+	c.headerKeys[cacheKey] = keys
+	return keys, nil
 }
 
-// Decrypt some bytes
-func (c *Crypter) Decrypt(data []byte) (plaintext []byte, err error) {
-	nonceSize := c.gcm.NonceSize()
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err = c.gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return plaintext, err
+// headerKeysKey identifies the kdf params of an envelope header
+func headerKeysKey(params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + params[name]
 	}
-	return plaintext, nil
+	return strings.Join(pairs, ",")
 }
 
-// Encrypt some bytes
-func (c *Crypter) Encrypt(data []byte) (ciphertext []byte, err error) {
+// open tries each key in turn, returning the plaintext of the first that authenticates
+func open(keys []*Key, nonce, ciphertext, additionalData []byte) (plaintext []byte, err error) {
+	for _, k := range keys {
+		plaintext, err = k.aead.Open(nil, nonce, ciphertext, additionalData)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
+
+// Encrypt some bytes, sealing them in the configured format. Envelopes are bound to ctx,
+// but the legacy raw format is not, as its consumers can not know to use associated data
+func (c *Crypter) Encrypt(data []byte, ctx aad.Context) (ciphertext []byte, err error) {
 	nonce := make([]byte, c.gcm.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
 		return ciphertext, err
 	}
-	ciphertext = c.gcm.Seal(nonce, nonce, data, nil)
-	return ciphertext, nil
+	if c.c.Format == envelope.FormatRaw {
+		return c.gcm.Seal(nonce, nonce, data, nil), nil
+	}
+	h := envelope.Header{
+		Module: ModuleName,
+		KeyID:  c.k.ID,
+		Params: c.headerParams(c.k),
+		Nonce:  nonce,
+		Bound:  true,
+	}
+	return envelope.Marshal(h, c.gcm.Seal(nil, nonce, data, ctx.Bytes()), c.c.Format == envelope.FormatArmor)
+}
+
+// headerParams returns the params recorded in the envelope header of data sealed with k,
+// which let decryptors derive k from its key file
+func (c *Crypter) headerParams(k *Key) map[string]string {
+	params := k.KDF.params()
+	params["cipher"] = c.c.Params["cipher"]
+	if len(k.Salt) > 0 {
+		params["salt"] = base64.StdEncoding.EncodeToString(k.Salt)
+	}
+	return params
 }
 
-// DecryptionKeys returns the encryption keys
+// DecryptionKeys returns all keys that are not retired, wrapped with the key-encryption key if there is one.
+// Keys are only included in Secrets when there is (see ErrIncludeKeysWithoutKEK).
 func (c *Crypter) DecryptionKeys() ([]key.Key, error) {
-	return []key.Key{c.k}, nil
+	keys := make([]key.Key, len(c.keys))
+	for i, k := range c.keys {
+		if c.kek == nil {
+			keys[i] = k
+			continue
+		}
+		w, err := c.wrap(k)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = w
+	}
+	return keys, nil
+}
+
+// deriveKey derives the cipher key of fileKey with the kdf and salt, and the AEAD sealing
+// with it. A key with an ID in the key file keeps it, and any other is identified by the
+// fingerprint of its derived cipher key
+func deriveKey(cipherName string, kdf *KDF, salt []byte, fileKey *Key) (*Key, error) {
+	var key *Key
+	switch kdf.Name {
+	case KDFScrypt:
+		var err error
+		key, err = NewScryptKey(cipherName, fileKey.Password, salt, kdf.N, kdf.R, kdf.P)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create key: %s", err.Error())
+		}
+	default:
+		newHasher, err := hasherFor(kdf.Hash)
+		if err != nil {
+			return nil, err
+		}
+		key = NewKey(cipherName, newHasher(), fileKey.Password)
+		key.KDF = kdf
+	}
+	if fileKey.ID != "" {
+		key.ID = fileKey.ID
+	}
+	key.Active = fileKey.Active
+
+	var block cipher.Block
+	var err error
+	switch cipherName {
+	case "":
+		fallthrough
+	case "aes":
+		block, err = aes.NewCipher(key.derived)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported cipher %s", cipherName)
+	}
+	key.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// hasherFor returns the constructor of the hash the legacy kdf hashes passwords with
+func hasherFor(name string) (func() hash.Hash, error) {
+	switch name {
+	case "":
+		fallthrough
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	case "md5":
+		return md5.New, nil
+	}
+	return nil, fmt.Errorf("unsupported hash %s", name)
 }
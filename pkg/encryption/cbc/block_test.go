@@ -3,16 +3,21 @@ package cbc
 import (
 	//	"crypto/sha512"
 	"io"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
 )
 
 var (
 	someData1 = `Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.`
 	jsonKey1  = `{"password":"elloOliv3R!420"}`
 
+	testContext = aad.Context{Namespace: "web", Name: "db-credentials", Key: "password"}
+
 	aesMd5Config = conf.Encryption{
 		Module: "cbc",
 		Params: map[string]string{
@@ -22,6 +27,11 @@ var (
 	}
 )
 
+// newDefaultConfig returns a config with default params, that is safe to mutate
+func newDefaultConfig() conf.Encryption {
+	return conf.Encryption{Module: "cbc", Params: map[string]string{}}
+}
+
 func newReaderFromString(s string) io.Reader {
 	return strings.NewReader(s)
 }
@@ -34,7 +44,7 @@ func TestBadCipher(t *testing.T) {
 			"cipher": "xxx420",
 			"hash":   "md5",
 		},
-	}, newReaderFromString(jsonKey1))
+	}, newReaderFromString(jsonKey1), nil)
 	if err == nil || err.Error() != expectedErrString {
 		t.Errorf("creating CBC module with cipher 'xxx420' should have failed with '%s' but got '%v'", expectedErrString, err)
 		t.Fail()
@@ -42,7 +52,7 @@ func TestBadCipher(t *testing.T) {
 }
 
 func TestAES(t *testing.T) {
-	c, err := New(aesMd5Config, newReaderFromString(jsonKey1))
+	c, err := New(aesMd5Config, newReaderFromString(jsonKey1), nil)
 	if err != nil {
 		t.Errorf("error creating new CBC encryption module: %s", err.Error())
 		t.Fail()
@@ -69,12 +79,12 @@ func TestAES(t *testing.T) {
 		t.Errorf("expected padded hashed key password '%s' but got '%s'", expectedHashedPw, c.k.paddedHashedPassword)
 	}
 
-	enc, err := c.Encrypt([]byte(someData1))
+	enc, err := c.Encrypt([]byte(someData1), testContext)
 	if err != nil {
 		t.Errorf("error encrypting data: %s", err.Error())
 	}
 
-	dec, err := c.Decrypt(enc)
+	dec, err := c.Decrypt(enc, testContext)
 	if err != nil {
 		t.Errorf("error decrypting data: %s", err.Error())
 	}
@@ -90,7 +100,7 @@ func TestAESHashes(t *testing.T) {
 	for _, h := range allowedHashes {
 		cfg := aesMd5Config
 		cfg.Params["hash"] = h
-		_, err := New(cfg, newReaderFromString(jsonKey1))
+		_, err := New(cfg, newReaderFromString(jsonKey1), nil)
 		if err != nil {
 			t.Errorf("error creating new CBC encryption module with hash %s: %s", h, err.Error())
 			t.Fail()
@@ -100,7 +110,7 @@ func TestAESHashes(t *testing.T) {
 	for _, h := range failHashes {
 		cfg := aesMd5Config
 		cfg.Params["hash"] = h
-		_, err := New(cfg, newReaderFromString(jsonKey1))
+		_, err := New(cfg, newReaderFromString(jsonKey1), nil)
 		if err == nil {
 			t.Errorf("creating new CBC encryption module with hash %s should have failed", h)
 			t.Fail()
@@ -108,3 +118,106 @@ func TestAESHashes(t *testing.T) {
 	}
 
 }
+
+func TestFormats(t *testing.T) {
+	for _, format := range []string{"", envelope.FormatEnvelope, envelope.FormatArmor, envelope.FormatRaw} {
+		cfg := newDefaultConfig()
+		cfg.Format = format
+		c, err := New(cfg, newReaderFromString(jsonKey1), nil)
+		if err != nil {
+			t.Fatalf("[%s] error creating new CBC encryption module: %s", format, err.Error())
+		}
+		enc, err := c.Encrypt([]byte(someData1), testContext)
+		if err != nil {
+			t.Fatalf("[%s] error encrypting data: %s", format, err.Error())
+		}
+		h, _, err := envelope.Unmarshal(enc)
+		if format == envelope.FormatRaw {
+			if err != envelope.ErrNotEnvelope {
+				t.Errorf("[%s] expected raw ciphertext, but got %v", format, err)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("[%s] expected an envelope, but got %v", format, err)
+			}
+			expectedParams := map[string]string{"cipher": "aes", "kdf": "legacy", "hash": "md5"}
+			if h.Module != ModuleName || h.KeyID != c.k.ID || !reflect.DeepEqual(h.Params, expectedParams) {
+				t.Errorf("[%s] unexpected envelope header %+v", format, *h)
+			}
+		}
+		// Decrypt dispatches on the header, so any format decrypts with any crypter for the key
+		dec, err := New(newDefaultConfig(), newReaderFromString(jsonKey1), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := dec.Decrypt(enc, testContext)
+		if err != nil {
+			t.Fatalf("[%s] error decrypting data: %s", format, err.Error())
+		}
+		if string(plaintext) != someData1 {
+			t.Errorf("[%s] decrypted data '%s' should have been '%s'", format, plaintext, someData1)
+		}
+	}
+
+	_, err := New(conf.Encryption{Module: "cbc", Format: "pgp", Params: map[string]string{}}, newReaderFromString(jsonKey1), nil)
+	if err == nil || err.Error() != "unsupported encryption format pgp" {
+		t.Errorf("expected an unsupported format error but got %v", err)
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	c, err := New(newDefaultConfig(), newReaderFromString(jsonKey1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := c.Encrypt([]byte(someData1), testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(newDefaultConfig(), newReaderFromString(`{"password":"someoneElse"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedErrString := "envelope was sealed with key " + c.k.ID + ", which is not in the keyring"
+	if _, err = other.Decrypt(enc, testContext); err == nil || err.Error() != expectedErrString {
+		t.Errorf("expected error '%s' but got '%v'", expectedErrString, err)
+	}
+	if _, err = other.Decrypt([]byte("short"), testContext); err != ErrCiphertextTooShort {
+		t.Errorf("expected error '%v' but got '%v'", ErrCiphertextTooShort, err)
+	}
+}
+
+func TestDecryptWrongContext(t *testing.T) {
+	c, err := New(newDefaultConfig(), newReaderFromString(jsonKey1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := c.Encrypt([]byte(someData1), testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ctx := range []aad.Context{
+		{Namespace: "other", Name: testContext.Name, Key: testContext.Key},
+		{Namespace: testContext.Namespace, Name: "other", Key: testContext.Key},
+		{Namespace: testContext.Namespace, Name: testContext.Name, Key: "other"},
+	} {
+		if _, err = c.Decrypt(enc, ctx); err == nil {
+			t.Errorf("ciphertext encrypted for %s should not decrypt in %s", testContext, ctx)
+		}
+	}
+
+	// the legacy raw format has no associated data, so it can not be bound
+	cfg := newDefaultConfig()
+	cfg.Format = envelope.FormatRaw
+	raw, err := New(cfg, newReaderFromString(jsonKey1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err = raw.Encrypt([]byte(someData1), testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Decrypt(enc, aad.Context{}); err != nil {
+		t.Errorf("raw ciphertext should decrypt in any context, but got %v", err)
+	}
+}
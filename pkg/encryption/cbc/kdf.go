@@ -0,0 +1,112 @@
+package cbc
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KDFLegacy derives the cipher key by hex encoding a hash of the password, and
+	// padding or trimming that to the cipher's key size. It is unsalted and keeps
+	// far less entropy than the key size, so it is only here for existing consumers
+	KDFLegacy = "legacy"
+	// KDFScrypt derives the cipher key from the password and the salt stored in the key file with scrypt
+	KDFScrypt = "scrypt"
+
+	defaultScryptN = 1 << 15
+	defaultScryptR = 8
+	defaultScryptP = 1
+
+	// minSaltLength is the shortest salt we accept for salted key derivation
+	minSaltLength = 16
+)
+
+var (
+	// ErrMissingSalt is returned when a salted kdf is selected, but the key file has no (or too short of a) salt
+	ErrMissingSalt = fmt.Errorf("the %s kdf needs a salt of at least %d bytes in the key file", KDFScrypt, minSaltLength)
+)
+
+// KDF records which key derivation function turned a Key's password into the
+// cipher key, and its parameters, so decryptors can derive the same key
+type KDF struct {
+	Name string `json:"name" yaml:"name"`
+	// Hash is the hash the legacy kdf uses
+	Hash string `json:"hash,omitempty" yaml:"hash,omitempty"`
+	// N, R and P are the scrypt cost parameters
+	N int `json:"n,omitempty" yaml:"n,omitempty"`
+	R int `json:"r,omitempty" yaml:"r,omitempty"`
+	P int `json:"p,omitempty" yaml:"p,omitempty"`
+}
+
+// kdfFromParams reads the kdf selection and its parameters from the encryption params
+func kdfFromParams(params map[string]string) (*KDF, error) {
+	switch params["kdf"] {
+	case KDFLegacy:
+		return &KDF{Name: KDFLegacy, Hash: params["hash"]}, nil
+	case KDFScrypt:
+		k := KDF{Name: KDFScrypt}
+		var err error
+		if k.N, err = intParam(params, "scrypt-n", defaultScryptN); err != nil {
+			return nil, err
+		}
+		if k.R, err = intParam(params, "scrypt-r", defaultScryptR); err != nil {
+			return nil, err
+		}
+		if k.P, err = intParam(params, "scrypt-p", defaultScryptP); err != nil {
+			return nil, err
+		}
+		return &k, nil
+	default:
+		return nil, fmt.Errorf("unsupported kdf %s", params["kdf"])
+	}
+}
+
+// params returns the kdf parameters, in the form of the encryption params that select them
+func (k *KDF) params() map[string]string {
+	p := map[string]string{"kdf": k.Name}
+	switch k.Name {
+	case KDFLegacy:
+		p["hash"] = k.Hash
+	case KDFScrypt:
+		p["scrypt-n"] = strconv.Itoa(k.N)
+		p["scrypt-r"] = strconv.Itoa(k.R)
+		p["scrypt-p"] = strconv.Itoa(k.P)
+	}
+	return p
+}
+
+func intParam(params map[string]string, name string, def int) (int, error) {
+	s, ok := params[name]
+	if !ok || s == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", name, s)
+	}
+	return i, nil
+}
+
+// NewScryptKey derives a Key for the cipher from the password and salt with scrypt
+func NewScryptKey(cipherName string, password string, salt []byte, n, r, p int) (*Key, error) {
+	if len(salt) < minSaltLength {
+		return nil, ErrMissingSalt
+	}
+	size, ok := cipherKeyByteLength[cipherName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher %s", cipherName)
+	}
+	derived, err := scrypt.Key([]byte(password), salt, n, r, p, size)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:       fingerprint(derived),
+		Password: password,
+		Salt:     salt,
+		KDF:      &KDF{Name: KDFScrypt, N: n, R: r, P: p},
+		derived:  derived,
+	}, nil
+}
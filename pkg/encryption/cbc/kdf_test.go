@@ -0,0 +1,126 @@
+package cbc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+)
+
+var (
+	// salt is base64 for "0123456789abcdef"
+	jsonSaltedKey1 = `{"password":"elloOliv3R!420","salt":"MDEyMzQ1Njc4OWFiY2RlZg=="}`
+)
+
+func scryptConfig() conf.Encryption {
+	return conf.Encryption{
+		Module: "cbc",
+		Params: map[string]string{
+			"kdf":      "scrypt",
+			"scrypt-n": "1024",
+		},
+	}
+}
+
+func TestScrypt(t *testing.T) {
+	c, err := New(scryptConfig(), newReaderFromString(jsonSaltedKey1), nil)
+	if err != nil {
+		t.Fatalf("error creating new CBC encryption module: %s", err.Error())
+	}
+	if len(c.k.derived) != 32 {
+		t.Errorf("expected a 32 byte derived key but got %d bytes", len(c.k.derived))
+	}
+	if string(c.k.derived) == c.k.paddedHashedPassword {
+		t.Errorf("scrypt kdf should not use the legacy derived key")
+	}
+
+	// the same password and salt must derive the same key
+	c2, err := New(scryptConfig(), newReaderFromString(jsonSaltedKey1), nil)
+	if err != nil {
+		t.Fatalf("error creating new CBC encryption module: %s", err.Error())
+	}
+	enc, err := c.Encrypt([]byte(someData1), testContext)
+	if err != nil {
+		t.Fatalf("error encrypting data: %s", err.Error())
+	}
+	dec, err := c2.Decrypt(enc, testContext)
+	if err != nil {
+		t.Fatalf("error decrypting data: %s", err.Error())
+	}
+	if string(dec) != someData1 {
+		t.Errorf("encrypted data '%s' should have been '%s'", dec, someData1)
+	}
+
+	// decryptors need the kdf and its parameters along with the password
+	keys, err := c.DecryptionKeys()
+	if err != nil {
+		t.Fatalf("error reading DecryptionKeys: %s", err.Error())
+	}
+	b, err := json.Marshal(keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":"dc897f628a7a64d9","password":"elloOliv3R!420","salt":"MDEyMzQ1Njc4OWFiY2RlZg==","kdf":{"name":"scrypt","n":1024,"r":8,"p":1},"active":true}`
+	if string(b) != expected {
+		t.Errorf("expected decryption key %s but got %s", expected, b)
+	}
+}
+
+func TestScryptErrors(t *testing.T) {
+	_, err := New(scryptConfig(), newReaderFromString(jsonKey1), nil)
+	if err == nil || err.Error() != "unable to create key: "+ErrMissingSalt.Error() {
+		t.Errorf("expected missing salt error but got %v", err)
+	}
+
+	cfg := scryptConfig()
+	cfg.Params["scrypt-n"] = "1000"
+	if _, err = New(cfg, newReaderFromString(jsonSaltedKey1), nil); err == nil {
+		t.Errorf("scrypt-n that is not a power of 2 should have failed")
+	}
+
+	cfg = scryptConfig()
+	cfg.Params["scrypt-r"] = "-1"
+	if _, err = New(cfg, newReaderFromString(jsonSaltedKey1), nil); err == nil {
+		t.Errorf("negative scrypt-r should have failed")
+	}
+
+	cfg = scryptConfig()
+	cfg.Params["kdf"] = "pbkdf1"
+	expectedErrString := "unsupported kdf pbkdf1"
+	if _, err = New(cfg, newReaderFromString(jsonSaltedKey1), nil); err == nil || err.Error() != expectedErrString {
+		t.Errorf("expected error '%s' but got '%v'", expectedErrString, err)
+	}
+}
+
+func TestDecryptWithHeaderKDF(t *testing.T) {
+	scrypt, err := New(scryptConfig(), newReaderFromString(jsonSaltedKey1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := New(newDefaultConfig(), newReaderFromString(jsonSaltedKey1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, test := range map[string]struct{ encrypter, decrypter *Crypter }{
+		"scrypt to legacy": {scrypt, legacy},
+		"legacy to scrypt": {legacy, scrypt},
+	} {
+		enc, err := test.encrypter.Encrypt([]byte(someData1), testContext)
+		if err != nil {
+			t.Fatalf("[%s] error encrypting data: %s", name, err.Error())
+		}
+		// the second decryption reuses the keys derived for the first
+		for i := 0; i < 2; i++ {
+			dec, err := test.decrypter.Decrypt(enc, testContext)
+			if err != nil {
+				t.Fatalf("[%s] expected the kdf params of the envelope header to derive the key, got %s", name, err.Error())
+			}
+			if string(dec) != someData1 {
+				t.Errorf("[%s] encrypted data '%s' should have been '%s'", name, dec, someData1)
+			}
+		}
+		if len(test.decrypter.headerKeys) != 1 {
+			t.Errorf("[%s] expected keys derived for 1 set of header params, got %d", name, len(test.decrypter.headerKeys))
+		}
+	}
+}
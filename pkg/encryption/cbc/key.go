@@ -1,6 +1,8 @@
 package cbc
 
 import (
+	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"hash"
@@ -33,13 +35,27 @@ func padOrTrim(d []byte, cipherName string) (padded []byte) {
 
 // Key stores the key used to decrypt a CBC encrypted jawn
 type Key struct {
-	Password             string `json:"password",yaml:"password"`
+	// ID is a fingerprint of the derived cipher key, recorded in the envelopes it seals
+	ID       string `json:"id,omitempty" yaml:"id,omitempty"`
+	Password string `json:"password" yaml:"password"`
+	// Salt is read from the key file, and used by salted kdfs
+	Salt []byte `json:"salt,omitempty" yaml:"salt,omitempty"`
+	// KDF records how the cipher key was derived from the Password
+	KDF *KDF `json:"kdf,omitempty" yaml:"kdf,omitempty"`
+	// Active marks the key of a keyring that encrypts
+	Active bool `json:"active,omitempty" yaml:"active,omitempty"`
+	// Retired marks keys of a keyring that are no longer used
+	Retired              bool `json:"retired,omitempty" yaml:"retired,omitempty"`
 	hasher               hash.Hash
 	hashedPassword       string
 	paddedHashedPassword string
+	// derived is the cipher key
+	derived []byte
+	// aead encrypts and decrypts with the derived key
+	aead cipher.AEAD
 }
 
-// NewKey takes a hasher and a password, and return a Key struct
+// NewKey takes a hasher and a password, and return a Key struct, using the legacy kdf
 func NewKey(cipherName string, hasher hash.Hash, password string) *Key {
 	k := Key{
 		Password: password,
@@ -48,9 +64,17 @@ func NewKey(cipherName string, hasher hash.Hash, password string) *Key {
 	hasher.Write([]byte(k.Password))
 	k.hashedPassword = hex.EncodeToString(k.hasher.Sum(nil))
 	k.paddedHashedPassword = string(padOrTrim([]byte(k.hashedPassword), cipherName))
+	k.derived = []byte(k.paddedHashedPassword)
+	k.ID = fingerprint(k.derived)
 	return &k
 }
 
+// fingerprint identifies a derived cipher key, without revealing it
+func fingerprint(derived []byte) string {
+	sum := sha256.Sum256(derived)
+	return hex.EncodeToString(sum[:8])
+}
+
 // Plaintext returns the password for this key
 func (k *Key) Plaintext() string {
 	return k.Password
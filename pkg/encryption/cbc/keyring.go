@@ -0,0 +1,167 @@
+package cbc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// generatedPasswordLength is the number of random bytes in passwords made by Rotate
+	generatedPasswordLength = 32
+	// KeyIDTimeFormat formats the default ID of keys made by Rotate
+	KeyIDTimeFormat = "20060102-150405"
+)
+
+var (
+	// ErrNoActiveKey is returned when a keyring has no active key to encrypt with
+	ErrNoActiveKey = errors.New("keyring has no active key")
+	// ErrMultipleActiveKeys is returned when a keyring has more than one active key
+	ErrMultipleActiveKeys = errors.New("keyring has more than one active key")
+	// ErrActiveKeyRetired is returned when the active key of a keyring is also retired
+	ErrActiveKeyRetired = errors.New("the active key of a keyring can not be retired")
+	// ErrEmptyKeyFile is returned when reading a key file that is empty, or was not given
+	ErrEmptyKeyFile = errors.New("key file is empty")
+)
+
+// Keyring holds the keys of a key file. A key file is either a single key, i.e.
+// {"password": "..."}, or a keyring of keys:
+// {"keys": [{"id": "2", "password": "...", "active": true}, {"id": "1", "password": "...", "retired": true}]}
+// Encryption uses the one active key, and retired keys are not used at all.
+type Keyring struct {
+	Keys []*Key `json:"keys" yaml:"keys"`
+}
+
+// ReadKeyring reads a key file, of either a single key or a keyring
+func ReadKeyring(r io.Reader) (*Keyring, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, ErrEmptyKeyFile
+	}
+	file := struct {
+		Key
+		Keys []*Key `json:"keys"`
+	}{}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, err
+	}
+	kr := Keyring{Keys: file.Keys}
+	if len(file.Keys) == 0 {
+		// a single key is the active key, so it can not be retired
+		k := file.Key
+		k.Active = true
+		kr.Keys = []*Key{&k}
+	}
+	if err := kr.Validate(); err != nil {
+		return nil, err
+	}
+	return &kr, nil
+}
+
+// Validate checks the keyring has exactly one active key, and no duplicate key IDs
+func (kr *Keyring) Validate() error {
+	ids := map[string]bool{}
+	var active *Key
+	for _, k := range kr.Keys {
+		if k.ID != "" {
+			if ids[k.ID] {
+				return fmt.Errorf("duplicate key id %s in keyring", k.ID)
+			}
+			ids[k.ID] = true
+		}
+		if !k.Active {
+			continue
+		}
+		if active != nil {
+			return ErrMultipleActiveKeys
+		}
+		if k.Retired {
+			return ErrActiveKeyRetired
+		}
+		active = k
+	}
+	if active == nil {
+		return ErrNoActiveKey
+	}
+	return nil
+}
+
+// Rotate adds a new active key with a random password and salt, and returns it.
+// If id is empty, the key ID is the current UTC time formatted with KeyIDTimeFormat.
+// Keys without an ID, i.e. from a single key file, are given their fingerprint ID,
+// so they can be retired
+func (kr *Keyring) Rotate(id string) (*Key, error) {
+	if id == "" {
+		id = time.Now().UTC().Format(KeyIDTimeFormat)
+	}
+	for _, k := range kr.Keys {
+		if k.ID == "" {
+			k.ID = k.fingerprintID()
+		}
+	}
+	for _, k := range kr.Keys {
+		if k.ID == id {
+			return nil, fmt.Errorf("duplicate key id %s in keyring", id)
+		}
+	}
+	password := make([]byte, generatedPasswordLength)
+	if _, err := io.ReadFull(rand.Reader, password); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, minSaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	for _, k := range kr.Keys {
+		k.Active = false
+	}
+	k := &Key{
+		ID:       id,
+		Password: base64.StdEncoding.EncodeToString(password),
+		Salt:     salt,
+		Active:   true,
+	}
+	kr.Keys = append([]*Key{k}, kr.Keys...)
+	return k, nil
+}
+
+// fingerprintID returns the ID a Crypter with the default params identifies k by, and
+// records in the envelopes it seals with k
+func (k *Key) fingerprintID() string {
+	return NewKey("aes", md5.New(), k.Password).ID
+}
+
+// Retire marks the key with the id as retired, so it is no longer used or handed out to decrypt
+func (kr *Keyring) Retire(id string) error {
+	for _, k := range kr.Keys {
+		if k.ID != id {
+			continue
+		}
+		if k.Active {
+			return ErrActiveKeyRetired
+		}
+		k.Retired = true
+		return nil
+	}
+	return fmt.Errorf("no key with id %s in keyring", id)
+}
+
+// Write writes the keyring as a key file
+func (kr *Keyring) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(kr, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
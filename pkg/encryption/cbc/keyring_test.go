@@ -0,0 +1,167 @@
+package cbc
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	jsonKeyring1 = `{"keys": [
+  {"id": "3", "password": "third"},
+  {"id": "2", "password": "second", "active": true},
+  {"id": "1", "password": "first", "retired": true}
+]}`
+)
+
+func TestReadKeyring(t *testing.T) {
+	kr, err := ReadKeyring(newReaderFromString(jsonKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kr.Keys) != 1 || !kr.Keys[0].Active || kr.Keys[0].Password != "elloOliv3R!420" {
+		t.Errorf("expected a single key file to read as a keyring of one active key, got %+v", kr.Keys)
+	}
+
+	kr, err = ReadKeyring(newReaderFromString(jsonKeyring1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kr.Keys) != 3 {
+		t.Errorf("expected 3 keys, got %d", len(kr.Keys))
+	}
+
+	tests := map[string]string{
+		`{"keys": [{"id": "1", "password": "a"}]}`:                                               ErrNoActiveKey.Error(),
+		`{"keys": [{"id": "1", "password": "a", "active": true, "retired": true}]}`:              ErrActiveKeyRetired.Error(),
+		`{"keys": [{"password": "a", "active": true}, {"password": "b", "active": true}]}`:       ErrMultipleActiveKeys.Error(),
+		`{"keys": [{"id": "1", "password": "a", "active": true}, {"id": "1", "password": "b"}]}`: "duplicate key id 1 in keyring",
+		`{"password": "a", "retired": true}`:                                                     ErrActiveKeyRetired.Error(),
+	}
+	for raw, expectedErrString := range tests {
+		if _, err := ReadKeyring(newReaderFromString(raw)); err == nil || err.Error() != expectedErrString {
+			t.Errorf("expected error '%s' reading %s, but got '%v'", expectedErrString, raw, err)
+		}
+	}
+}
+
+func TestKeyringCrypter(t *testing.T) {
+	c, err := New(newDefaultConfig(), newReaderFromString(jsonKeyring1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.k.ID != "2" {
+		t.Errorf("expected to encrypt with the active key 2, but got %s", c.k.ID)
+	}
+	keys, err := c.DecryptionKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Plaintext() != "third" || keys[1].Plaintext() != "second" {
+		t.Errorf("expected the decryption keys to be all keys that are not retired, got %v", keys)
+	}
+
+	// data sealed by any key that is not retired decrypts
+	for _, raw := range []string{`{"password": "third"}`, `{"id": "3", "password": "third"}`, `{"id": "2", "password": "second"}`} {
+		other, err := New(newDefaultConfig(), newReaderFromString(raw), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := other.Encrypt([]byte(someData1), testContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := c.Decrypt(enc, testContext)
+		if err != nil {
+			t.Errorf("unable to decrypt data sealed by %s: %s", raw, err.Error())
+		} else if string(dec) != someData1 {
+			t.Errorf("decrypted data '%s' should have been '%s'", dec, someData1)
+		}
+	}
+
+	// but retired keys are not used
+	retired, err := New(newDefaultConfig(), newReaderFromString(`{"id": "1", "password": "first"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := retired.Encrypt([]byte(someData1), testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decrypt(enc, testContext); err == nil {
+		t.Errorf("data sealed by a retired key should not decrypt")
+	}
+}
+
+func TestCrypterWithoutActiveKey(t *testing.T) {
+	// a crypter without an active key has nothing to encrypt with, so it is never made
+	for _, raw := range []string{`{"password": "a", "retired": true}`, `{"keys": [{"id": "1", "password": "a", "retired": true}]}`} {
+		if c, err := New(newDefaultConfig(), newReaderFromString(raw), nil); err == nil {
+			t.Errorf("expected an error creating a crypter from %s, got %+v", raw, c)
+		}
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	kr, err := ReadKeyring(newReaderFromString(jsonKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := New(newDefaultConfig(), newReaderFromString(jsonKey1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := old.Encrypt([]byte(someData1), testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := kr.Rotate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.ID == "" || !k.Active || len(k.Salt) != minSaltLength || k.Password == "" {
+		t.Errorf("expected a new active key with an id, password and salt, got %+v", k)
+	}
+	if _, err := kr.Rotate(k.ID); err == nil {
+		t.Errorf("rotating to a duplicate key id should have failed")
+	}
+	// the key from the single key file gets the fingerprint ID its envelopes record
+	if migrated := kr.Keys[1]; migrated.ID != old.k.ID {
+		t.Errorf("expected the key from before rotation to get the id %s, got %q", old.k.ID, migrated.ID)
+	}
+	if err := kr.Retire(k.ID); err != ErrActiveKeyRetired {
+		t.Errorf("expected %v retiring the active key, got %v", ErrActiveKeyRetired, err)
+	}
+	if err := kr.Retire("nope"); err == nil {
+		t.Errorf("retiring a missing key should have failed")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := kr.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(newDefaultConfig(), bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.k.ID != k.ID {
+		t.Errorf("expected the rotated key %s to be active, got %s", k.ID, c.k.ID)
+	}
+	// the key from before rotation still decrypts
+	dec, err := c.Decrypt(enc, testContext)
+	if err != nil {
+		t.Fatalf("unable to decrypt data sealed before rotation: %s", err.Error())
+	}
+	if string(dec) != someData1 {
+		t.Errorf("decrypted data '%s' should have been '%s'", dec, someData1)
+	}
+	if err := kr.Retire(old.k.ID); err != nil {
+		t.Errorf("expected the key from before rotation to be retired by its id, got %v", err)
+	}
+
+	// the key from before rotation has no salt, so the keyring can not use scrypt until it is retired
+	cfg := scryptConfig()
+	if _, err := New(cfg, bytes.NewReader(buf.Bytes()), nil); err == nil || err.Error() != "unable to create key: "+ErrMissingSalt.Error() {
+		t.Errorf("expected missing salt error but got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package cbc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
+)
+
+var (
+	// ErrNoKEK is returned when unwrapping keys with a Crypter that has no key-encryption key
+	ErrNoKEK = errors.New("no key-encryption key to unwrap keys with")
+	// ErrIncludeKeysWithoutKEK is returned when decryption keys are to be included in Secrets, but
+	// there is no key-encryption key to wrap them with, which would leave them in plaintext
+	ErrIncludeKeysWithoutKEK = errors.New("include_decryption_keys needs a key-encryption key to wrap the keys with (see --creds-key-decryption-key)")
+)
+
+// WrappedKey is a Key encrypted with a key-encryption key (KEK), so it can be
+// shipped along with the data it decrypts, without giving that data away
+type WrappedKey struct {
+	// ID is the ID of the wrapped Key
+	ID string `json:"id" yaml:"id"`
+	// Wrapped is the JSON of the Key, sealed in an envelope by the KEK
+	Wrapped []byte `json:"wrapped" yaml:"wrapped"`
+}
+
+// Plaintext returns the base64 encoded wrapped key; it is only plaintext to holders of the KEK
+func (w *WrappedKey) Plaintext() string {
+	return base64.StdEncoding.EncodeToString(w.Wrapped)
+}
+
+// wrapContext binds a wrapped key to its ID
+func wrapContext(id string) aad.Context {
+	return aad.Context{Key: id}
+}
+
+// newKEK creates the Crypter wrapping decryption keys from the key file read from r,
+// with the same cipher and kdf as c. It returns nil if r is nil or empty
+func newKEK(c conf.Encryption, r io.Reader) (*Crypter, error) {
+	if r == nil {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	kc := c
+	kc.Format = envelope.FormatEnvelope
+	// the KEK itself is never included
+	kc.IncludeDecryptionKeys = false
+	kc.Params = make(map[string]string, len(c.Params))
+	for k, v := range c.Params {
+		kc.Params[k] = v
+	}
+	return New(kc, bytes.NewReader(raw), nil)
+}
+
+// wrap encrypts k with the KEK
+func (c *Crypter) wrap(k *Key) (*WrappedKey, error) {
+	js, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := c.kek.Encrypt(js, wrapContext(k.ID))
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedKey{ID: k.ID, Wrapped: wrapped}, nil
+}
+
+// UnwrapKey decrypts a WrappedKey with the KEK of the Crypter
+func (c *Crypter) UnwrapKey(w *WrappedKey) (*Key, error) {
+	if c.kek == nil {
+		return nil, ErrNoKEK
+	}
+	js, err := c.kek.Decrypt(w.Wrapped, wrapContext(w.ID))
+	if err != nil {
+		return nil, err
+	}
+	k := Key{}
+	if err := json.Unmarshal(js, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
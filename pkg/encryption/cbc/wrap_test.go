@@ -0,0 +1,89 @@
+package cbc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var (
+	jsonKEK1 = `{"password":"1234567890ABCDEF1234567890ABCDEF"}`
+)
+
+func TestWrappedDecryptionKeys(t *testing.T) {
+	c, err := New(newDefaultConfig(), newReaderFromString(jsonKeyring1), newReaderFromString(jsonKEK1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := c.DecryptionKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 decryption keys, got %d", len(keys))
+	}
+	for i, expectedPassword := range []string{"third", "second"} {
+		w, ok := keys[i].(*WrappedKey)
+		if !ok {
+			t.Fatalf("expected a wrapped key, got %T", keys[i])
+		}
+		js, err := json.Marshal(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(js), expectedPassword) {
+			t.Errorf("wrapped key %s leaks its password", js)
+		}
+		k, err := c.UnwrapKey(w)
+		if err != nil {
+			t.Fatalf("unable to unwrap key %s: %s", w.ID, err.Error())
+		}
+		if k.Password != expectedPassword || k.ID != w.ID {
+			t.Errorf("expected to unwrap key %s with password %s, got %s with %s", w.ID, expectedPassword, k.ID, k.Password)
+		}
+
+		// a wrapped key is bound to its ID
+		if _, err := c.UnwrapKey(&WrappedKey{ID: "other", Wrapped: w.Wrapped}); err == nil {
+			t.Errorf("key %s should not unwrap as another key", w.ID)
+		}
+	}
+
+	// holders of a different KEK can not unwrap
+	other, err := New(newDefaultConfig(), newReaderFromString(jsonKeyring1), newReaderFromString(jsonKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.UnwrapKey(keys[0].(*WrappedKey)); err == nil {
+		t.Errorf("key should not unwrap with another KEK")
+	}
+
+	// without a KEK, keys are not wrapped
+	plain, err := New(newDefaultConfig(), newReaderFromString(jsonKeyring1), newReaderFromString(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err = plain.DecryptionKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys[0].(*Key); !ok {
+		t.Errorf("expected a plain key without a KEK, got %T", keys[0])
+	}
+	if _, err := plain.UnwrapKey(&WrappedKey{}); err != ErrNoKEK {
+		t.Errorf("expected %v, got %v", ErrNoKEK, err)
+	}
+}
+
+func TestIncludeKeysWithoutKEK(t *testing.T) {
+	c := newDefaultConfig()
+	c.IncludeDecryptionKeys = true
+	for _, kek := range []string{"", jsonKEK1} {
+		_, err := New(c, newReaderFromString(jsonKeyring1), newReaderFromString(kek))
+		if kek == "" && err != ErrIncludeKeysWithoutKEK {
+			t.Errorf("expected %v including keys without a KEK, got %v", ErrIncludeKeysWithoutKEK, err)
+		}
+		if kek != "" && err != nil {
+			t.Errorf("expected to include keys wrapped by a KEK, got %v", err)
+		}
+	}
+}
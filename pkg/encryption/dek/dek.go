@@ -0,0 +1,190 @@
+package dek
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
+)
+
+const (
+	// ModuleName selects the dek module. Its envelopes carry a data key wrapped by the cbc
+	// keyring; modules built on NewWithKEK record their own name instead
+	ModuleName = "dek"
+
+	// ScopeSecret uses one data key for all the items of a Secret. This is the default scope
+	ScopeSecret = "secret"
+	// ScopeKey uses a data key for each item of a Secret
+	ScopeKey = "key"
+
+	// dataKeyLength is the size of data keys, for AES-256
+	dataKeyLength = 32
+)
+
+var (
+	// ErrMissingWrappedKey is returned when decrypting an envelope without a wrapped data key
+	ErrMissingWrappedKey = errors.New("envelope has no wrapped data key")
+)
+
+// KEK is a key-encryption key, that wraps data keys and binds them to a context
+type KEK interface {
+	Encrypt([]byte, aad.Context) ([]byte, error)
+	Decrypt([]byte, aad.Context) ([]byte, error)
+	// DecryptionKeys are the keys to unwrap data keys with, if any can be handed out
+	DecryptionKeys() ([]key.Key, error)
+}
+
+// Crypter does envelope encryption: each Secret (or item, depending on the scope)
+// is encrypted with its own random data key, which is wrapped by a key-encryption key
+// and kept in the envelope header. Rotating the key-encryption key only changes how
+// the data keys are wrapped.
+type Crypter struct {
+	c     conf.Encryption
+	name  string
+	scope string
+	kek   KEK
+
+	mu sync.Mutex
+	// dataKeys are the data keys made so far, by the context they are scoped to
+	dataKeys map[aad.Context]*dataKey
+}
+
+// dataKey is a data key, and its wrapped form
+type dataKey struct {
+	aead    cipher.AEAD
+	wrapped []byte
+}
+
+// New creates a new Crypter encryption module. The key-encryption key is a cbc
+// keyring, created by the cbc module from the same config and key files
+func New(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (*Crypter, error) {
+	if err := envelope.RequireHeader(c.Format); err != nil {
+		return nil, err
+	}
+	kc := c
+	kc.Format = envelope.FormatEnvelope
+	kc.Params = make(map[string]string, len(c.Params))
+	for k, v := range c.Params {
+		kc.Params[k] = v
+	}
+	kek, err := cbc.New(kc, credsKeyReader, keysDecrypterReader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create key-encryption key: %s", err.Error())
+	}
+	return NewWithKEK(c, ModuleName, kek)
+}
+
+// NewWithKEK creates a new Crypter encryption module, wrapping data keys with kek, and
+// recording name as the module in its envelopes
+func NewWithKEK(c conf.Encryption, name string, kek KEK) (*Crypter, error) {
+	if err := envelope.RequireHeader(c.Format); err != nil {
+		return nil, err
+	}
+	m := Crypter{
+		c:        c,
+		name:     name,
+		scope:    c.Params["scope"],
+		kek:      kek,
+		dataKeys: map[aad.Context]*dataKey{},
+	}
+	switch m.scope {
+	case "":
+		m.scope = ScopeSecret
+	case ScopeSecret, ScopeKey:
+	default:
+		return nil, fmt.Errorf("unsupported %s scope %s", name, m.scope)
+	}
+	return &m, nil
+}
+
+// scoped returns the context a data key for ctx is scoped to, and wrapped with
+func scoped(scope string, ctx aad.Context) aad.Context {
+	if scope == ScopeSecret {
+		ctx.Key = ""
+	}
+	return ctx
+}
+
+// dataKeyFor returns the data key for ctx, making and wrapping a new one the first time its scope is seen
+func (c *Crypter) dataKeyFor(ctx aad.Context) (*dataKey, error) {
+	scope := scoped(c.scope, ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if dk, ok := c.dataKeys[scope]; ok {
+		return dk, nil
+	}
+	k := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return nil, err
+	}
+	aead, err := envelope.NewAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := c.kek.Encrypt(k, scope)
+	if err != nil {
+		return nil, err
+	}
+	dk := &dataKey{aead: aead, wrapped: wrapped}
+	c.dataKeys[scope] = dk
+	return dk, nil
+}
+
+// Encrypt some bytes with the data key for ctx, sealing them in an envelope with the wrapped data key
+func (c *Crypter) Encrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	dk, err := c.dataKeyFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, dk.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	h := envelope.Header{
+		Module:     c.name,
+		Params:     map[string]string{"cipher": "aes", "scope": c.scope},
+		Nonce:      nonce,
+		Bound:      true,
+		WrappedKey: dk.wrapped,
+	}
+	return envelope.Marshal(h, dk.aead.Seal(nil, nonce, data, ctx.Bytes()), c.c.Format == envelope.FormatArmor)
+}
+
+// Decrypt some bytes found in ctx, unwrapping the data key from the envelope with the key-encryption key
+func (c *Crypter) Decrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	h, ciphertext, err := envelope.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.Module != c.name {
+		return nil, fmt.Errorf("envelope was sealed by encryption module %s, not %s", h.Module, c.name)
+	}
+	if len(h.WrappedKey) == 0 {
+		return nil, ErrMissingWrappedKey
+	}
+	k, err := c.kek.Decrypt(h.WrappedKey, scoped(h.Params["scope"], ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key: %s", err.Error())
+	}
+	aead, err := envelope.NewAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("envelope nonce is %d bytes, expected %d", len(h.Nonce), aead.NonceSize())
+	}
+	return aead.Open(nil, h.Nonce, ciphertext, ctx.Bytes())
+}
+
+// DecryptionKeys returns the key-encryption keys, as data keys travel wrapped in each envelope
+func (c *Crypter) DecryptionKeys() ([]key.Key, error) {
+	return c.kek.DecryptionKeys()
+}
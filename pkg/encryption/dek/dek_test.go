@@ -0,0 +1,136 @@
+package dek
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	testutil "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
+)
+
+var (
+	jsonKey1 = `{"password":"elloOliv3R!420"}`
+
+	ctxPassword = aad.Context{Namespace: "web", Name: "db-credentials", Key: "password"}
+	ctxUsername = aad.Context{Namespace: "web", Name: "db-credentials", Key: "username"}
+	ctxOther    = aad.Context{Namespace: "web", Name: "api-credentials", Key: "password"}
+)
+
+func newConfig(scope string) conf.Encryption {
+	return conf.Encryption{Module: ModuleName, Params: map[string]string{"scope": scope}}
+}
+
+func newCrypter(t *testing.T, c conf.Encryption, keyFile string) *Crypter {
+	m, err := New(c, strings.NewReader(keyFile), nil)
+	if err != nil {
+		t.Fatalf("error creating new dek encryption module: %s", err.Error())
+	}
+	return m
+}
+
+func wrappedKeyOf(t *testing.T, data []byte) []byte {
+	h, _, err := envelope.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.WrappedKey
+}
+
+func TestScopes(t *testing.T) {
+	for _, scope := range []string{"", ScopeSecret, ScopeKey} {
+		c := newCrypter(t, newConfig(scope), jsonKey1)
+		// a fresh Crypter with the same key-encryption key unwraps the data key from the envelope
+		testutil.RoundTrip(t, c, newCrypter(t, newConfig(scope), jsonKey1))
+
+		encrypted := map[aad.Context][]byte{}
+		for _, ctx := range []aad.Context{ctxPassword, ctxUsername, ctxOther} {
+			enc, err := c.Encrypt([]byte(testutil.SomeData), ctx)
+			if err != nil {
+				t.Fatalf("[%s] error encrypting data: %s", scope, err.Error())
+			}
+			encrypted[ctx] = enc
+		}
+		samePerSecret := bytes.Equal(wrappedKeyOf(t, encrypted[ctxPassword]), wrappedKeyOf(t, encrypted[ctxUsername]))
+		if scope != ScopeKey && !samePerSecret {
+			t.Errorf("[%s] expected items of a Secret to share a data key", scope)
+		}
+		if scope == ScopeKey && samePerSecret {
+			t.Errorf("[%s] expected each item to have its own data key", scope)
+		}
+		if bytes.Equal(wrappedKeyOf(t, encrypted[ctxPassword]), wrappedKeyOf(t, encrypted[ctxOther])) {
+			t.Errorf("[%s] expected Secrets to have their own data keys", scope)
+		}
+
+		// items copied into another Secret do not decrypt
+		if _, err := c.Decrypt(encrypted[ctxPassword], ctxOther); err == nil {
+			t.Errorf("[%s] data encrypted for %s should not decrypt in %s", scope, ctxPassword, ctxOther)
+		}
+	}
+}
+
+func TestKEKRotation(t *testing.T) {
+	enc, err := newCrypter(t, newConfig(""), jsonKey1).Encrypt([]byte(testutil.SomeData), ctxPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := cbc.ReadKeyring(strings.NewReader(jsonKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kr.Rotate("2"); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := kr.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	c := newCrypter(t, newConfig(""), buf.String())
+
+	// data keys wrapped before the rotation still unwrap, and new ones are wrapped with the new key
+	dec, err := c.Decrypt(enc, ctxPassword)
+	if err != nil {
+		t.Fatalf("unable to decrypt data from before the rotation: %s", err.Error())
+	}
+	if string(dec) != testutil.SomeData {
+		t.Errorf("decrypted data '%s' should have been '%s'", dec, testutil.SomeData)
+	}
+	enc, err = c.Encrypt([]byte(testutil.SomeData), ctxPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _, err := envelope.Unmarshal(wrappedKeyOf(t, enc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.KeyID != "2" {
+		t.Errorf("expected the data key to be wrapped with the new key 2, got %s", h.KeyID)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cfg := newConfig("")
+	cfg.Format = envelope.FormatRaw
+	if _, err := New(cfg, strings.NewReader(jsonKey1), nil); err != envelope.ErrRawFormat {
+		t.Errorf("expected %v, got %v", envelope.ErrRawFormat, err)
+	}
+	if _, err := New(newConfig("mapping"), strings.NewReader(jsonKey1), nil); err == nil || err.Error() != "unsupported dek scope mapping" {
+		t.Errorf("expected an unsupported scope error, got %v", err)
+	}
+
+	c := newCrypter(t, newConfig(""), jsonKey1)
+	raw, err := envelope.Marshal(envelope.Header{Module: ModuleName}, []byte(testutil.SomeData), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decrypt(raw, ctxPassword); err != ErrMissingWrappedKey {
+		t.Errorf("expected %v, got %v", ErrMissingWrappedKey, err)
+	}
+	if _, err := c.Decrypt([]byte(testutil.SomeData), ctxPassword); err != envelope.ErrNotEnvelope {
+		t.Errorf("expected %v, got %v", envelope.ErrNotEnvelope, err)
+	}
+}
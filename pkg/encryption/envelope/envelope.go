@@ -0,0 +1,154 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Version is the envelope format version written by Marshal
+	Version = 1
+
+	// FormatEnvelope seals ciphertext in a binary envelope. This is the default format
+	FormatEnvelope = "envelope"
+	// FormatArmor seals ciphertext in an envelope, ASCII armored as a PEM block
+	FormatArmor = "armor"
+	// FormatRaw is the legacy bare ciphertext, without any header
+	FormatRaw = "raw"
+
+	// ArmorType is the PEM block type of armored envelopes
+	ArmorType = "K8S SECRET PROJECTOR MESSAGE"
+)
+
+var (
+	// magic marks the start of every envelope
+	magic = []byte("K8SP")
+
+	// ErrNotEnvelope is returned when unmarshalling data that is not an envelope, i.e. legacy raw ciphertext
+	ErrNotEnvelope = errors.New("data is not an envelope")
+	// ErrTruncated is returned when an envelope is shorter than its header claims
+	ErrTruncated = errors.New("envelope is truncated")
+	// ErrRawFormat is returned when a module that keeps wrapped data keys in the envelope header
+	// is configured with the raw format, which has no header to keep them in
+	ErrRawFormat = fmt.Errorf("encryption module needs an envelope format, not %s", FormatRaw)
+)
+
+// Header describes how the payload of an envelope was encrypted, so decryptors
+// do not need to know the module, key or parameters out of band
+type Header struct {
+	// Version is the envelope format version
+	Version int `json:"-"`
+	// Module is the name of the encryption module that sealed the envelope
+	Module string `json:"module"`
+	// KeyID identifies the key the payload was encrypted with
+	KeyID string `json:"key_id,omitempty"`
+	// Params are module specific, like the cipher and key derivation parameters
+	Params map[string]string `json:"params,omitempty"`
+	// Nonce is the nonce the payload was sealed with
+	Nonce []byte `json:"nonce,omitempty"`
+	// Bound is true when the payload is bound to its aad.Context as associated data
+	Bound bool `json:"bound,omitempty"`
+	// WrappedKey is the data key the payload was encrypted with, itself
+	// encrypted by a key-encryption key, for modules doing envelope encryption
+	WrappedKey []byte `json:"wrapped_key,omitempty"`
+	// Recipients hold the data key wrapped for each recipient, for modules doing public key encryption
+	Recipients []Recipient `json:"recipients,omitempty"`
+}
+
+// Recipient is the data key of an envelope, wrapped for the holder of one private key
+type Recipient struct {
+	// KeyID identifies the recipient's key
+	KeyID string `json:"key_id"`
+	// WrappedKey is the data key, encrypted to the recipient's public key
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// ValidFormat returns an error if format is not a known format. An empty format is the default FormatEnvelope
+func ValidFormat(format string) error {
+	switch format {
+	case "", FormatEnvelope, FormatArmor, FormatRaw:
+		return nil
+	}
+	return fmt.Errorf("unsupported encryption format %s", format)
+}
+
+// RequireHeader returns an error if format is not a known format, or is FormatRaw, for
+// modules that keep wrapped data keys in the envelope header
+func RequireHeader(format string) error {
+	if err := ValidFormat(format); err != nil {
+		return err
+	}
+	if format == FormatRaw {
+		return ErrRawFormat
+	}
+	return nil
+}
+
+// NewAEAD returns the AES-GCM AEAD of a data key, for modules sealing payloads with data keys
+func NewAEAD(k []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Marshal encodes the header and payload into an envelope:
+// magic, version byte, big endian uint32 header length, JSON header and payload.
+// If armor is true, the envelope is encoded as a PEM block
+func Marshal(h Header, payload []byte, armor bool) ([]byte, error) {
+	rawHeader, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(magic)+5+len(rawHeader)+len(payload)))
+	buf.Write(magic)
+	buf.WriteByte(Version)
+	binary.Write(buf, binary.BigEndian, uint32(len(rawHeader)))
+	buf.Write(rawHeader)
+	buf.Write(payload)
+	if !armor {
+		return buf.Bytes(), nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: ArmorType, Bytes: buf.Bytes()}), nil
+}
+
+// Unmarshal decodes an envelope, armored or not, into its header and payload.
+// It returns ErrNotEnvelope if data does not start with an envelope
+func Unmarshal(data []byte) (*Header, []byte, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN "+ArmorType+"-----")) {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, nil, fmt.Errorf("invalid armored envelope")
+		}
+		data = block.Bytes
+	}
+	if !bytes.HasPrefix(data, magic) {
+		return nil, nil, ErrNotEnvelope
+	}
+	data = data[len(magic):]
+	if len(data) < 5 {
+		return nil, nil, ErrTruncated
+	}
+	version := int(data[0])
+	if version != Version {
+		return nil, nil, fmt.Errorf("unsupported envelope version %d", version)
+	}
+	headerLen := binary.BigEndian.Uint32(data[1:5])
+	data = data[5:]
+	if uint64(len(data)) < uint64(headerLen) {
+		return nil, nil, ErrTruncated
+	}
+	h := Header{}
+	if err := json.Unmarshal(data[:headerLen], &h); err != nil {
+		return nil, nil, fmt.Errorf("invalid envelope header: %s", err.Error())
+	}
+	h.Version = version
+	return &h, data[headerLen:], nil
+}
@@ -0,0 +1,92 @@
+package envelope
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+)
+
+var testHeader = Header{
+	Version: Version,
+	Module:  "cbc",
+	KeyID:   "0123456789abcdef",
+	Params:  map[string]string{"cipher": "aes", "kdf": "legacy", "hash": "md5"},
+	Nonce:   []byte("twelve bytes"),
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	payload := []byte("some ciphertext")
+	for _, armor := range []bool{false, true} {
+		data, err := Marshal(testHeader, payload, armor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if armor && !strings.HasPrefix(string(data), "-----BEGIN "+ArmorType+"-----\n") {
+			t.Errorf("expected an armored envelope but got %q", data)
+		}
+		h, p, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("[armor=%t] unexpected error: %s", armor, err.Error())
+		}
+		if !reflect.DeepEqual(*h, testHeader) {
+			t.Errorf("[armor=%t] expected header %+v but got %+v", armor, testHeader, *h)
+		}
+		if !bytes.Equal(p, payload) {
+			t.Errorf("[armor=%t] expected payload %q but got %q", armor, payload, p)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data, err := Marshal(testHeader, []byte("some ciphertext"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		data []byte
+		err  string
+	}{
+		"raw":       {[]byte("twelve bytes and some ciphertext"), ErrNotEnvelope.Error()},
+		"truncated": {data[:20], ErrTruncated.Error()},
+		"version":   {append([]byte("K8SP\x02"), data[5:]...), "unsupported envelope version 2"},
+		"armor":     {[]byte("-----BEGIN " + ArmorType + "-----\nnope\n"), "invalid armored envelope"},
+	}
+	for name, test := range tests {
+		_, _, err := Unmarshal(test.data)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("[%s] expected error '%s' but got '%v'", name, test.err, err)
+		}
+	}
+}
+
+func TestRequireHeader(t *testing.T) {
+	for format, expected := range map[string]string{
+		"":             "",
+		FormatEnvelope: "",
+		FormatArmor:    "",
+		FormatRaw:      ErrRawFormat.Error(),
+		"zip":          "unsupported encryption format zip",
+	} {
+		err := RequireHeader(format)
+		if (err == nil && expected != "") || (err != nil && err.Error() != expected) {
+			t.Errorf("expected '%s' for format %q, got '%v'", expected, format, err)
+		}
+	}
+}
+
+func TestNewAEAD(t *testing.T) {
+	aead, err := NewAEAD(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if d, err := aead.Open(nil, nonce, aead.Seal(nil, nonce, []byte("data"), nil), nil); err != nil || string(d) != "data" {
+		t.Errorf("expected the AEAD to open what it sealed, got %q %v", d, err)
+	}
+	if _, err := NewAEAD([]byte("short")); err == nil {
+		t.Errorf("expected an error for a key of the wrong size")
+	}
+}
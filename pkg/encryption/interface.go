@@ -6,10 +6,10 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"plugin"
 
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
-	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
 	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
 )
 
@@ -21,27 +21,45 @@ var (
 // Module allows a projection to encrypt its data elements. This allows implementation to
 // be abstracted from use.
 type Module interface {
-	// Encrypt takes some []byte and returns them encrypted
-	Encrypt([]byte) ([]byte, error)
+	// Encrypt takes some []byte and returns them encrypted, sealed in an envelope.Header
+	// describing the module, key and parameters, unless the legacy raw format is configured.
+	// The ciphertext is bound to the aad.Context it is projected to
+	Encrypt([]byte, aad.Context) ([]byte, error)
 	// Keys returns a list of key.Key used to decrypt the result of Encrypt()
 	DecryptionKeys() ([]key.Key, error)
-	// Decrypt takes some []byte and returns them unencrypted
-	Decrypt([]byte) ([]byte, error)
+	// Decrypt takes some []byte found in the aad.Context and returns them unencrypted,
+	// dispatching on the envelope header if there is one
+	Decrypt([]byte, aad.Context) ([]byte, error)
 }
 
-// NewModuleFromEncryptionConfig returns a new encryption module
-// it handles opening up any files referenced in the configs and passing io.Readers to
-// the referenced encryption module's setup
+// NewModuleFromEncryptionConfig returns a new encryption module, of the registered module
+// named by c.Module, once its params are valid (see Registration.ValidateParams). It handles
+// opening up any files referenced in the configs and passing io.Readers to the module's Constructor
 func NewModuleFromEncryptionConfig(c conf.Encryption) (Module, error) {
+	r, ok := Lookup(c.Module)
+	if !ok {
+		return nil, fmt.Errorf("unsupported encryption module '%s'", c.Module)
+	}
 	// clean up the conf.Encryption, in case it was just unmarshalled and lacks some structs
 	if c.Params == nil {
 		c.Params = map[string]string{}
 	}
-	fCredsKeysFile, err := os.Open(c.CredsKeysFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open CredsKeysFilePath %s: %s", c.CredsKeysFilePath, err.Error())
+	if err := r.ValidateParams(c.Params); err != nil {
+		return nil, fmt.Errorf("invalid params for encryption module '%s': %s", c.Module, err.Error())
+	}
+
+	var fCredsKeysFile io.Reader
+	if c.CredsKeysFilePath != "" {
+		f, err := os.Open(c.CredsKeysFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open CredsKeysFilePath %s: %s", c.CredsKeysFilePath, err.Error())
+		}
+		defer f.Close()
+		fCredsKeysFile = f
+	} else {
+		// public key modules only need a private key to decrypt, so the creds keys file is optional
+		fCredsKeysFile = bytes.NewReader(nil)
 	}
-	defer fCredsKeysFile.Close()
 
 	var fKeysDecrypterReader io.Reader
 	if c.KeysDecrypterFilePath != "" {
@@ -56,23 +74,29 @@ func NewModuleFromEncryptionConfig(c conf.Encryption) (Module, error) {
 		fKeysDecrypterReader = ioutil.NopCloser(bytes.NewReader(nil))
 	}
 
-	switch c.Module {
-	case "plugin":
-		if c.PluginPath == "" {
-			return nil, ErrMissingPluginPath
-		}
-		p, err := plugin.Open(c.PluginPath)
-		if err != nil {
-			return nil, err
-		}
-		// lookup the New function:
-		new, err := p.Lookup("New")
-		if err != nil {
-			return nil, err
+	return r.New(c, fCredsKeysFile, fKeysDecrypterReader)
+}
+
+// Decrypt takes some []byte found in the aad.Context and returns them unencrypted, with the
+// module that sealed them. An envelope is opened by the registered module its header names,
+// made from c. When that is not the module c configures, c's params are dropped, as params
+// of one module mean nothing to another, and the header records what decrypting needs.
+// Legacy raw ciphertext has no header, and is decrypted by the module c configures
+func Decrypt(c conf.Encryption, data []byte, ctx aad.Context) ([]byte, error) {
+	h, _, err := envelope.Unmarshal(data)
+	switch err {
+	case nil:
+		if h.Module != c.Module {
+			c.Module = h.Module
+			c.Params = nil
 		}
-		return new.(func(conf.Encryption, io.Reader, io.Reader) (Module, error))(c, fCredsKeysFile, fKeysDecrypterReader)
-	case "cbc":
-		return cbc.New(c, fCredsKeysFile)
+	case envelope.ErrNotEnvelope:
+	default:
+		return nil, err
+	}
+	m, err := NewModuleFromEncryptionConfig(c)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("unsupported encryption module '%s'", c.Module)
+	return m.Decrypt(data, ctx)
 }
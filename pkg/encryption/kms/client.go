@@ -0,0 +1,106 @@
+package kms
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+const (
+	// APIVersion is the version of the Kubernetes KMS plugin API the kms module speaks
+	APIVersion = "v2"
+	// HealthzOK is the Healthz a KMS reports when it is able to encrypt and decrypt
+	HealthzOK = "ok"
+
+	// unixScheme prefixes unix socket endpoints, as in unix:///var/run/kms.sock
+	unixScheme = "unix://"
+)
+
+// Client calls a KMS plugin over the gRPC KMS plugin v2 API, on a unix socket. The
+// connection is made on first use, and again after it breaks
+type Client struct {
+	conn    *grpc.ClientConn
+	kms     kmsapi.KeyManagementServiceClient
+	timeout time.Duration
+}
+
+// NewClient creates a Client for the unix socket at endpoint, either a path or a
+// unix:// URL. Every call fails if it takes longer than timeout
+func NewClient(endpoint string, timeout time.Duration) (*Client, error) {
+	path := endpoint
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		if !strings.HasPrefix(endpoint, unixScheme) {
+			return nil, fmt.Errorf("unsupported kms endpoint %s, only unix sockets are supported", endpoint)
+		}
+		path = strings.TrimPrefix(endpoint, unixScheme)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("invalid kms endpoint %s", endpoint)
+	}
+	// unix:path takes relative paths as well as absolute ones
+	conn, err := grpc.NewClient("unix:"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid kms endpoint %s: %s", endpoint, err.Error())
+	}
+	return &Client{conn: conn, kms: kmsapi.NewKeyManagementServiceClient(conn), timeout: timeout}, nil
+}
+
+// withTimeout returns a Client sharing the connection of c, whose calls fail if they take longer than timeout
+func (c *Client) withTimeout(timeout time.Duration) *Client {
+	shared := *c
+	shared.timeout = timeout
+	return &shared
+}
+
+// call runs f with a context bounded by the timeout of c
+func (c *Client) call(method string, f func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	err := f(ctx)
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.DeadlineExceeded {
+		return fmt.Errorf("kms %s timed out after %s", method, c.timeout)
+	}
+	return fmt.Errorf("kms %s failed: %s", method, status.Convert(err).Message())
+}
+
+// Status asks the KMS for its health, and the ID of the key it encrypts with
+func (c *Client) Status() (resp *kmsapi.StatusResponse, err error) {
+	err = c.call("Status", func(ctx context.Context) error {
+		resp, err = c.kms.Status(ctx, &kmsapi.StatusRequest{})
+		return err
+	})
+	return resp, err
+}
+
+// Encrypt asks the KMS to encrypt some plaintext, usually a data key
+func (c *Client) Encrypt(req *kmsapi.EncryptRequest) (resp *kmsapi.EncryptResponse, err error) {
+	err = c.call("Encrypt", func(ctx context.Context) error {
+		resp, err = c.kms.Encrypt(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// Decrypt asks the KMS to decrypt some ciphertext it encrypted
+func (c *Client) Decrypt(req *kmsapi.DecryptRequest) (resp *kmsapi.DecryptResponse, err error) {
+	err = c.call("Decrypt", func(ctx context.Context) error {
+		resp, err = c.kms.Decrypt(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// Close closes the connection to the KMS
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
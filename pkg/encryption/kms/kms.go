@@ -0,0 +1,185 @@
+package kms
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/dek"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+const (
+	// ModuleName selects the kms module, and tells its envelopes apart from those of dek, as
+	// both keep a wrapped data key in the header, but only a KMS can unwrap a kms one
+	ModuleName = "kms"
+	// DefaultTimeout is how long calls to the KMS may take, unless the timeout param is set
+	DefaultTimeout = 3 * time.Second
+)
+
+var (
+	clientsMu sync.Mutex
+	// clients are the Clients shared by kms modules, by endpoint
+	clients = map[string]*Client{}
+
+	// ErrMissingEndpoint is returned when the kms module is configured without an endpoint param
+	ErrMissingEndpoint = fmt.Errorf("the %s encryption module needs an endpoint param", ModuleName)
+)
+
+// KEK wraps data keys with the current key of a KMS. The wrapped data key is sealed in
+// an envelope recording the KMS key ID and annotations, so it unwraps after the KMS rotates
+type KEK struct {
+	c *Client
+}
+
+// newUID makes a random ID for a request to the KMS
+func newUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Encrypt wraps a data key with the KMS. The KMS does not bind ciphertext to a
+// context, but the data sealed with the data key is bound to ctx
+func (k *KEK) Encrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	uid, err := newUID()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.c.Encrypt(&kmsapi.EncryptRequest{Plaintext: data, Uid: uid})
+	if err != nil {
+		return nil, err
+	}
+	if resp.KeyId == "" {
+		return nil, fmt.Errorf("kms returned no key id for request %s", uid)
+	}
+	h := envelope.Header{
+		Module: ModuleName,
+		KeyID:  resp.KeyId,
+		Params: make(map[string]string, len(resp.Annotations)),
+	}
+	for name, v := range resp.Annotations {
+		h.Params[name] = base64.StdEncoding.EncodeToString(v)
+	}
+	return envelope.Marshal(h, resp.Ciphertext, false)
+}
+
+// Decrypt unwraps a data key with the KMS key it was wrapped with
+func (k *KEK) Decrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	h, ciphertext, err := envelope.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.Module != ModuleName {
+		return nil, fmt.Errorf("data key was wrapped by encryption module %s, not %s", h.Module, ModuleName)
+	}
+	uid, err := newUID()
+	if err != nil {
+		return nil, err
+	}
+	req := kmsapi.DecryptRequest{
+		Ciphertext:  ciphertext,
+		Uid:         uid,
+		KeyId:       h.KeyID,
+		Annotations: make(map[string][]byte, len(h.Params)),
+	}
+	for name, v := range h.Params {
+		if req.Annotations[name], err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %s", name, err.Error())
+		}
+	}
+	resp, err := k.c.Decrypt(&req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// DecryptionKeys returns no keys: the keys never leave the KMS
+func (k *KEK) DecryptionKeys() ([]key.Key, error) {
+	return nil, nil
+}
+
+// New creates a new kms encryption module, wrapping data keys with the KMS plugin
+// listening on the unix socket of the endpoint param, over the gRPC KMS plugin v2 API.
+// Modules with the same endpoint share one connection to the KMS, until Shutdown.
+// The timeout param bounds each call to the KMS. Data keys are scoped like the dek
+// module, with the scope param
+func New(c conf.Encryption) (*dek.Crypter, error) {
+	endpoint := c.Params["endpoint"]
+	if endpoint == "" {
+		return nil, ErrMissingEndpoint
+	}
+	timeout := DefaultTimeout
+	if t := c.Params["timeout"]; t != "" {
+		var err error
+		if timeout, err = time.ParseDuration(t); err != nil {
+			return nil, fmt.Errorf("invalid %s timeout %s: %s", ModuleName, t, err.Error())
+		}
+	}
+	client, err := sharedClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithClient(c, client.withTimeout(timeout))
+}
+
+// sharedClient returns the Client of the KMS at endpoint, shared by the kms modules of
+// every projection mapping with that endpoint. It is made on first use, and closed by Shutdown
+func sharedClient(endpoint string) (*Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if client, ok := clients[endpoint]; ok {
+		return client, nil
+	}
+	client, err := NewClient(endpoint, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	clients[endpoint] = client
+	return client, nil
+}
+
+// Shutdown closes the Clients shared by the kms modules made by New, once the projector
+// is done with them. It returns the first error closing a Client
+func Shutdown() error {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	var first error
+	for endpoint, client := range clients {
+		if err := client.Close(); err != nil && first == nil {
+			first = fmt.Errorf("unable to close kms client of %s: %s", endpoint, err.Error())
+		}
+		delete(clients, endpoint)
+	}
+	return first
+}
+
+// NewWithClient creates a new kms encryption module wrapping data keys with the KMS
+// of client, once it reports it is healthy
+func NewWithClient(c conf.Encryption, client *Client) (*dek.Crypter, error) {
+	status, err := client.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status.Version != APIVersion {
+		return nil, fmt.Errorf("unsupported kms API version %s, expected %s", status.Version, APIVersion)
+	}
+	if status.Healthz != HealthzOK {
+		return nil, fmt.Errorf("kms is not healthy: %s", status.Healthz)
+	}
+	if status.KeyId == "" {
+		return nil, fmt.Errorf("kms reported no key id")
+	}
+	return dek.NewWithKEK(c, ModuleName, &KEK{c: client})
+}
@@ -0,0 +1,208 @@
+package kms
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	testutil "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+// serve serves s over gRPC on a unix socket, returning the endpoint and a func to stop it
+func serve(t *testing.T, s kmsapi.KeyManagementServiceServer) (string, func()) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "kms.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(s)
+	go srv.Serve(l)
+	return "unix://" + path, func() {
+		srv.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func newConfig(endpoint string) conf.Encryption {
+	return conf.Encryption{Module: ModuleName, Params: map[string]string{"endpoint": endpoint}}
+}
+
+func wrappedKeyHeader(t *testing.T, data []byte) *envelope.Header {
+	h, _, err := envelope.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh, _, err := envelope.Unmarshal(h.WrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wh
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	s, err := NewLocalService("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, stop := serve(t, s)
+	defer stop()
+
+	c, err := New(newConfig(endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := testutil.RoundTrip(t, c, c)
+	if h := wrappedKeyHeader(t, enc); h.KeyID != "1" {
+		t.Errorf("expected the data key to be wrapped with kms key 1, got %s", h.KeyID)
+	}
+	keys, err := c.DecryptionKeys()
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected no decryption keys, got %v (%v)", keys, err)
+	}
+
+	// the kms rotates, and data keys wrapped before still unwrap with a fresh module
+	if err := s.Rotate("2"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(newConfig(endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := d.Decrypt(enc, testutil.Context1)
+	if err != nil {
+		t.Fatalf("error decrypting data: %s", err.Error())
+	}
+	if string(dec) != testutil.SomeData {
+		t.Errorf("decrypted data '%s' should have been '%s'", dec, testutil.SomeData)
+	}
+	enc, err = d.Encrypt([]byte(testutil.SomeData), testutil.Context1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := wrappedKeyHeader(t, enc); h.KeyID != "2" {
+		t.Errorf("expected the data key to be wrapped with kms key 2, got %s", h.KeyID)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(newConfig("")); err != ErrMissingEndpoint {
+		t.Errorf("expected %v, got %v", ErrMissingEndpoint, err)
+	}
+	if _, err := New(newConfig("tcp://127.0.0.1:1234")); err == nil || err.Error() != "unsupported kms endpoint tcp://127.0.0.1:1234, only unix sockets are supported" {
+		t.Errorf("expected an unsupported endpoint error, got %v", err)
+	}
+	cfg := newConfig("/nonexistent/kms.sock")
+	cfg.Params["timeout"] = "soon"
+	if _, err := New(cfg); err == nil || !strings.HasPrefix(err.Error(), "invalid kms timeout soon: ") {
+		t.Errorf("expected an invalid timeout error, got %v", err)
+	}
+	if _, err := New(newConfig("/nonexistent/kms.sock")); err == nil {
+		t.Errorf("expected an error connecting to a missing socket")
+	}
+
+	s, err := NewLocalService("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, stop := serve(t, s)
+	defer stop()
+	s.SetHealthz("key unavailable")
+	if _, err := New(newConfig(endpoint)); err == nil || err.Error() != "kms is not healthy: key unavailable" {
+		t.Errorf("expected an unhealthy kms error, got %v", err)
+	}
+	s.SetHealthz(HealthzOK)
+	cfg = newConfig(endpoint)
+	cfg.Format = envelope.FormatRaw
+	if _, err := New(cfg); err == nil {
+		t.Errorf("expected an error with the %s format", envelope.FormatRaw)
+	}
+	c, err := NewClient(endpoint, DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Decrypt(&kmsapi.DecryptRequest{KeyId: "3"}); err == nil || err.Error() != "kms Decrypt failed: unknown key id 3" {
+		t.Errorf("expected an unknown key error, got %v", err)
+	}
+}
+
+// stuckService is a KMS that never answers Status
+type stuckService struct {
+	kmsapi.UnimplementedKeyManagementServiceServer
+}
+
+func (s *stuckService) Status(ctx context.Context, req *kmsapi.StatusRequest) (*kmsapi.StatusResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTimeout(t *testing.T) {
+	endpoint, stop := serve(t, &stuckService{})
+	defer stop()
+
+	c, err := NewClient(endpoint, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Status(); err == nil || err.Error() != "kms Status timed out after 50ms" {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestSharedClient(t *testing.T) {
+	// close the clients of the other tests
+	if err := Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewLocalService("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, stop := serve(t, s)
+	defer stop()
+
+	c, err := New(newConfig(endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig(endpoint)
+	cfg.Params["timeout"] = "1s"
+	if _, err := New(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if client, ok := clients[endpoint]; !ok || len(clients) != 1 {
+		t.Fatalf("expected modules with the same endpoint to share a client, got %v", clients)
+	} else if client.timeout != DefaultTimeout {
+		t.Errorf("expected the shared client to keep the default timeout, got %s", client.timeout)
+	}
+
+	if err := Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 0 {
+		t.Errorf("expected Shutdown to close every client, got %v", clients)
+	}
+	if _, err := c.Encrypt([]byte(testutil.SomeData), testutil.Context1); err == nil {
+		t.Errorf("expected a module to fail to reach the kms after Shutdown")
+	}
+	// modules made after Shutdown connect again
+	d, err := New(newConfig(endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown()
+	testutil.RoundTrip(t, d, d)
+}
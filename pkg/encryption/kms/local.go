@@ -0,0 +1,116 @@
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+const (
+	// localNonceAnnotation carries the nonce of ciphertext encrypted by a LocalService
+	localNonceAnnotation = "nonce.kms.local"
+	localKeyLength       = 32
+)
+
+// LocalService is a KMS plugin keeping its keys in memory, served over gRPC with NewServer.
+// It stands in for a real KMS in tests and local development, and must not be used to
+// protect real secrets
+type LocalService struct {
+	mu      sync.Mutex
+	healthz string
+	keyID   string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalService creates a LocalService, with a random key of the keyID
+func NewLocalService(keyID string) (*LocalService, error) {
+	s := LocalService{healthz: HealthzOK, keys: map[string]cipher.AEAD{}}
+	if err := s.Rotate(keyID); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Rotate makes a new random key of the keyID, and encrypts with it from now on.
+// Older keys are kept to decrypt with
+func (s *LocalService) Rotate(keyID string) error {
+	if keyID == "" {
+		return errors.New("missing key id")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[keyID]; ok {
+		return fmt.Errorf("duplicate key id %s", keyID)
+	}
+	k := make([]byte, localKeyLength)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	s.keys[keyID] = aead
+	s.keyID = keyID
+	return nil
+}
+
+// SetHealthz sets the Healthz reported by Status, to simulate an unhealthy KMS
+func (s *LocalService) SetHealthz(healthz string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthz = healthz
+}
+
+// Status implements kmsapi.KeyManagementServiceServer
+func (s *LocalService) Status(context.Context, *kmsapi.StatusRequest) (*kmsapi.StatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &kmsapi.StatusResponse{Version: APIVersion, Healthz: s.healthz, KeyId: s.keyID}, nil
+}
+
+// Encrypt implements kmsapi.KeyManagementServiceServer
+func (s *LocalService) Encrypt(ctx context.Context, req *kmsapi.EncryptRequest) (*kmsapi.EncryptResponse, error) {
+	s.mu.Lock()
+	keyID, aead := s.keyID, s.keys[s.keyID]
+	s.mu.Unlock()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return &kmsapi.EncryptResponse{
+		Ciphertext:  aead.Seal(nil, nonce, req.Plaintext, []byte(keyID)),
+		KeyId:       keyID,
+		Annotations: map[string][]byte{localNonceAnnotation: nonce},
+	}, nil
+}
+
+// Decrypt implements kmsapi.KeyManagementServiceServer
+func (s *LocalService) Decrypt(ctx context.Context, req *kmsapi.DecryptRequest) (*kmsapi.DecryptResponse, error) {
+	s.mu.Lock()
+	aead, ok := s.keys[req.KeyId]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", req.KeyId)
+	}
+	nonce := req.Annotations[localNonceAnnotation]
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("missing or invalid %s annotation", localNonceAnnotation)
+	}
+	plaintext, err := aead.Open(nil, nonce, req.Ciphertext, []byte(req.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	return &kmsapi.DecryptResponse{Plaintext: plaintext}, nil
+}
@@ -0,0 +1,14 @@
+package kms
+
+import (
+	"google.golang.org/grpc"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+// NewServer creates a gRPC server serving s as the KMS plugin v2 API, i.e. to serve a
+// LocalService on a unix socket for local development
+func NewServer(s kmsapi.KeyManagementServiceServer) *grpc.Server {
+	srv := grpc.NewServer()
+	kmsapi.RegisterKeyManagementServiceServer(srv, s)
+	return srv
+}
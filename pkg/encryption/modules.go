@@ -0,0 +1,91 @@
+package encryption
+
+import (
+	"io"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/dek"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/kms"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/plugin"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/rsaoaep"
+)
+
+const (
+	// positiveInt matches positive integers
+	positiveInt = `^[1-9][0-9]*$`
+	// duration matches the durations of time.ParseDuration, i.e. 1m30s
+	duration = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$`
+)
+
+// cbcParams are the params of the cbc module, and of the dek module's key-encryption key
+var cbcParams = map[string]Param{
+	"cipher": {Description: "block cipher", Default: "aes", Values: []string{"aes"}},
+	"hash": {
+		Description: "hash of the password deriving the key, with the legacy kdf",
+		Default:     "md5",
+		Values:      []string{"md5", "sha1", "sha256", "sha512"},
+	},
+	"kdf":      {Description: "key derivation function", Default: cbc.KDFLegacy, Values: []string{cbc.KDFLegacy, cbc.KDFScrypt}},
+	"scrypt-n": {Description: "scrypt CPU/memory cost", Default: "32768", Pattern: positiveInt},
+	"scrypt-r": {Description: "scrypt block size", Default: "8", Pattern: positiveInt},
+	"scrypt-p": {Description: "scrypt parallelization", Default: "1", Pattern: positiveInt},
+}
+
+// scopeParam selects how many items share a data key, in the dek and kms modules
+var scopeParam = Param{Description: "what a data key is made for", Default: dek.ScopeSecret, Values: []string{dek.ScopeSecret, dek.ScopeKey}}
+
+func init() {
+	Register(Registration{
+		Name: cbc.ModuleName,
+		New: func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error) {
+			return cbc.New(c, credsKeyReader, keysDecrypterReader)
+		},
+		Params: cbcParams,
+	})
+
+	dekParams := map[string]Param{"scope": scopeParam}
+	for name, p := range cbcParams {
+		dekParams[name] = p
+	}
+	Register(Registration{
+		Name: dek.ModuleName,
+		New: func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error) {
+			return dek.New(c, credsKeyReader, keysDecrypterReader)
+		},
+		Params: dekParams,
+	})
+
+	Register(Registration{
+		Name: rsaoaep.ModuleName,
+		New: func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error) {
+			return rsaoaep.New(c, credsKeyReader)
+		},
+		Params: map[string]Param{},
+	})
+
+	Register(Registration{
+		Name: kms.ModuleName,
+		New: func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error) {
+			return kms.New(c)
+		},
+		Params: map[string]Param{
+			"endpoint": {Description: "unix socket of the KMS, i.e. unix:///var/run/kms.sock", Required: true},
+			"timeout":  {Description: "timeout of calls to the KMS", Default: kms.DefaultTimeout.String(), Pattern: duration},
+			"scope":    scopeParam,
+		},
+		Shutdown: kms.Shutdown,
+	})
+
+	// plugins check their own params
+	Register(Registration{
+		Name: "plugin",
+		New: func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error) {
+			if c.PluginPath == "" {
+				return nil, ErrMissingPluginPath
+			}
+			return plugin.Open(c.PluginPath, c, credsKeyReader, keysDecrypterReader)
+		},
+		Shutdown: plugin.Shutdown,
+	})
+}
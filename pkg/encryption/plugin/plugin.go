@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
+)
+
+// shutdownTimeout is how long Shutdown waits for a plugin to exit, before killing it
+const shutdownTimeout = 5 * time.Second
+
+var (
+	mu sync.Mutex
+	// processes are the running plugins, by path. Each plugin process serves all the
+	// Modules made from it
+	processes = map[string]*process{}
+)
+
+// process is a running plugin, talking JSON-RPC over its stdin and stdout
+type process struct {
+	path string
+	cmd  *exec.Cmd
+	rpc  *rpc.Client
+	// exited is closed once the plugin exited and was waited for, with the error of Wait
+	exited  chan struct{}
+	waitErr error
+}
+
+// pipes joins the stdout and stdin of a plugin process
+type pipes struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// eofReader is the stdout of a plugin process. It closes done once a read fails, which is
+// at EOF once the plugin exits: only then may the process be waited for, as Wait closes
+// stdout, and must not be called before all reads from it are done
+type eofReader struct {
+	r    io.Reader
+	once sync.Once
+	done chan struct{}
+}
+
+func (e *eofReader) Read(b []byte) (int, error) {
+	n, err := e.r.Read(b)
+	if err != nil {
+		e.once.Do(func() { close(e.done) })
+	}
+	return n, err
+}
+
+// start runs the plugin executable at path, unless it is running already
+func start(path string) (*process, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if p, ok := processes[abs]; ok {
+		return p, nil
+	}
+	cmd := exec.Command(abs)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start plugin %s: %s", path, err.Error())
+	}
+	out := &eofReader{r: stdout, done: make(chan struct{})}
+	p := &process{
+		path:   path,
+		cmd:    cmd,
+		rpc:    jsonrpc.NewClient(pipes{Reader: out, WriteCloser: stdin}),
+		exited: make(chan struct{}),
+	}
+	processes[abs] = p
+	// reap the plugin once the rpc client read all it wrote, so the next Open starts it again
+	go func() {
+		<-out.done
+		p.waitErr = cmd.Wait()
+		mu.Lock()
+		if processes[abs] == p {
+			delete(processes, abs)
+		}
+		mu.Unlock()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// stop closes the stdin of the plugin, which makes Serve return, and waits for the plugin
+// to exit. A plugin still running after shutdownTimeout is killed
+func (p *process) stop() error {
+	p.rpc.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(shutdownTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+		return fmt.Errorf("plugin %s did not exit after %s, and was killed", p.path, shutdownTimeout)
+	}
+	if p.waitErr != nil {
+		return fmt.Errorf("plugin %s exited: %s", p.path, p.waitErr.Error())
+	}
+	return nil
+}
+
+// Shutdown stops all the running plugins, and waits for them to exit. The Clients opened
+// before are unusable after, but Open starts the plugins again. It returns the first error
+// of a plugin that did not exit cleanly
+func Shutdown() error {
+	mu.Lock()
+	paths := make([]string, 0, len(processes))
+	for path := range processes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	running := make([]*process, len(paths))
+	for i, path := range paths {
+		running[i] = processes[path]
+	}
+	mu.Unlock()
+	var first error
+	for _, p := range running {
+		if err := p.stop(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (p *process) call(method string, req interface{}, resp interface{}) error {
+	err := p.rpc.Call(serviceName+"."+method, req, resp)
+	if err == nil {
+		return nil
+	}
+	// errors of the module come back as a ServerError, anything else means the plugin is gone
+	if serr, ok := err.(rpc.ServerError); ok {
+		return errors.New(string(serr))
+	}
+	return fmt.Errorf("plugin %s exited: %s", p.path, err.Error())
+}
+
+// Client is a Module served by a plugin process
+type Client struct {
+	p  *process
+	id int
+}
+
+// Open creates a Module in the plugin executable at path, starting the plugin if it
+// is not running yet. The key files are read here, and sent to the plugin
+func Open(path string, c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (*Client, error) {
+	req := NewRequest{ProtocolVersion: ProtocolVersion, Config: c}
+	var err error
+	if req.CredsKey, err = ioutil.ReadAll(credsKeyReader); err != nil {
+		return nil, err
+	}
+	if req.KeysDecrypter, err = ioutil.ReadAll(keysDecrypterReader); err != nil {
+		return nil, err
+	}
+	p, err := start(path)
+	if err != nil {
+		return nil, err
+	}
+	var resp NewResponse
+	if err := p.call("New", &req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.ProtocolVersion != ProtocolVersion {
+		return nil, fmt.Errorf("plugin %s speaks protocol version %d, expected %d", path, resp.ProtocolVersion, ProtocolVersion)
+	}
+	return &Client{p: p, id: resp.ModuleID}, nil
+}
+
+// Encrypt implements Module
+func (c *Client) Encrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	var resp CryptResponse
+	if err := c.p.call("Encrypt", &CryptRequest{ModuleID: c.id, Data: data, Context: ctx}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Decrypt implements Module
+func (c *Client) Decrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	var resp CryptResponse
+	if err := c.p.call("Decrypt", &CryptRequest{ModuleID: c.id, Data: data, Context: ctx}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// DecryptionKeys implements Module
+func (c *Client) DecryptionKeys() ([]key.Key, error) {
+	var resp KeysResponse
+	if err := c.p.call("DecryptionKeys", &KeysRequest{ModuleID: c.id}, &resp); err != nil {
+		return nil, err
+	}
+	keys := make([]key.Key, len(resp.Keys))
+	for i, k := range resp.Keys {
+		keys[i] = &Key{data: k}
+	}
+	return keys, nil
+}
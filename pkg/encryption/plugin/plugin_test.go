@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testutil "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+)
+
+// servePluginEnv makes the test binary serve the cbc module as a plugin, instead of running the tests
+const servePluginEnv = "K8S_SECRET_PROJECTOR_TEST_PLUGIN"
+
+var (
+	jsonKey1 = `{"password":"elloOliv3R!420"}`
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(servePluginEnv) != "" {
+		err := Serve(func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error) {
+			return cbc.New(c, credsKeyReader, keysDecrypterReader)
+		})
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testPlugin writes a plugin executable running the test binary as a plugin, returning its path
+func testPlugin(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "cbc")
+	script := "#!/bin/sh\n" + servePluginEnv + "=1 exec " + os.Args[0] + "\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func newConfig() conf.Encryption {
+	return conf.Encryption{Module: "plugin", Params: map[string]string{"hash": "md5"}}
+}
+
+func TestPlugin(t *testing.T) {
+	path, cleanup := testPlugin(t)
+	defer cleanup()
+
+	c, err := Open(path, newConfig(), strings.NewReader(jsonKey1), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the plugin encrypts just like the builtin module
+	local, err := cbc.New(newConfig(), strings.NewReader(jsonKey1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.RoundTrip(t, c, local)
+	testutil.RoundTrip(t, local, c)
+
+	// a second module shares the plugin process
+	d, err := Open(path, newConfig(), strings.NewReader(jsonKey1), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.p != c.p {
+		t.Errorf("expected modules of the same plugin to share a process")
+	}
+	testutil.RoundTrip(t, c, d)
+
+	keys, err := c.DecryptionKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	localKeys, err := local.DecryptionKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Plaintext() != localKeys[0].Plaintext() {
+		t.Fatalf("expected decryption keys %v, got %v", localKeys, keys)
+	}
+	js, err := json.Marshal(keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	localJS, err := json.Marshal(localKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != string(localJS) {
+		t.Errorf("expected decryption key %s, got %s", localJS, js)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := Open("bin/plugins/missing", newConfig(), strings.NewReader(jsonKey1), strings.NewReader("")); err == nil || !strings.HasPrefix(err.Error(), "unable to start plugin bin/plugins/missing: ") {
+		t.Errorf("expected an error starting a missing plugin, got %v", err)
+	}
+
+	path, cleanup := testPlugin(t)
+	defer cleanup()
+	// errors of the module are returned by the plugin
+	if _, err := Open(path, newConfig(), strings.NewReader(""), strings.NewReader("")); err == nil || err.Error() != "unable to load key: "+cbc.ErrEmptyKeyFile.Error() {
+		t.Errorf("expected %v, got %v", cbc.ErrEmptyKeyFile, err)
+	}
+	c, err := Open(path, newConfig(), strings.NewReader(jsonKey1), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.id = 42
+	if _, err := c.Encrypt([]byte(testutil.SomeData), testutil.Context1); err == nil || err.Error() != "unknown module id 42" {
+		t.Errorf("expected an unknown module error, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	path, cleanup := testPlugin(t)
+	defer cleanup()
+
+	c, err := Open(path, newConfig(), strings.NewReader(jsonKey1), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Shutdown(); err != nil {
+		t.Fatalf("expected the plugin to exit cleanly, got %v", err)
+	}
+	mu.Lock()
+	running := len(processes)
+	mu.Unlock()
+	if running != 0 {
+		t.Errorf("expected no running plugins after Shutdown, got %d", running)
+	}
+	if _, err := c.Encrypt([]byte(testutil.SomeData), testutil.Context1); err == nil || !strings.HasPrefix(err.Error(), "plugin "+path+" exited: ") {
+		t.Errorf("expected an error using a shut down plugin, got %v", err)
+	}
+
+	// the plugin starts again on the next Open
+	d, err := Open(path, newConfig(), strings.NewReader(jsonKey1), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.p == c.p {
+		t.Errorf("expected a new plugin process after Shutdown")
+	}
+	testutil.RoundTrip(t, d, d)
+	if err := Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
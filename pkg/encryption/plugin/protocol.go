@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
+)
+
+// ProtocolVersion is the version of the plugin protocol. The projector refuses plugins
+// speaking another version
+const ProtocolVersion = 1
+
+// serviceName is the name the plugin methods are served under, i.e. Plugin.Encrypt
+const serviceName = "Plugin"
+
+// Module is an encryption module served by a plugin. It has the methods of
+// encryption.Module, which can not be imported here
+type Module interface {
+	Encrypt([]byte, aad.Context) ([]byte, error)
+	DecryptionKeys() ([]key.Key, error)
+	Decrypt([]byte, aad.Context) ([]byte, error)
+}
+
+// NewFunc creates a Module from its config, the creds keys file and the keys decrypter
+// file, like the constructors of the builtin encryption modules
+type NewFunc func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error)
+
+// NewRequest asks a plugin for a new Module. The contents of the key files are sent
+// along, so the plugin needs no access to the projector's filesystem
+type NewRequest struct {
+	ProtocolVersion int             `json:"protocol_version"`
+	Config          conf.Encryption `json:"config"`
+	CredsKey        []byte          `json:"creds_key,omitempty"`
+	KeysDecrypter   []byte          `json:"keys_decrypter,omitempty"`
+}
+
+// NewResponse identifies the Module made by a plugin, in later requests
+type NewResponse struct {
+	ProtocolVersion int `json:"protocol_version"`
+	ModuleID        int `json:"module_id"`
+}
+
+// CryptRequest asks a plugin Module to encrypt or decrypt some data found in the Context
+type CryptRequest struct {
+	ModuleID int         `json:"module_id"`
+	Data     []byte      `json:"data"`
+	Context  aad.Context `json:"context"`
+}
+
+// CryptResponse is the encrypted or decrypted data
+type CryptResponse struct {
+	Data []byte `json:"data"`
+}
+
+// KeysRequest asks a plugin Module for its decryption keys
+type KeysRequest struct {
+	ModuleID int `json:"module_id"`
+}
+
+// KeysResponse is the decryption keys of a plugin Module
+type KeysResponse struct {
+	Keys []KeyData `json:"keys"`
+}
+
+// KeyData is a decryption key of a plugin Module, as the JSON the projector puts in
+// the Secret, and its plaintext
+type KeyData struct {
+	JSON      json.RawMessage `json:"json"`
+	Plaintext string          `json:"plaintext"`
+}
+
+// Key is a decryption key returned by a plugin. It marshals to the same JSON as the
+// key did in the plugin
+type Key struct {
+	data KeyData
+}
+
+// Plaintext returns the plaintext of the key, as the plugin returned it
+func (k *Key) Plaintext() string {
+	return k.data.Plaintext
+}
+
+// MarshalJSON returns the JSON of the key in the plugin
+func (k *Key) MarshalJSON() ([]byte, error) {
+	return k.data.JSON, nil
+}
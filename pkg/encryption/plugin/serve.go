@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+)
+
+// server serves the Modules made by a NewFunc
+type server struct {
+	newModule NewFunc
+
+	mu      sync.Mutex
+	modules []Module
+}
+
+func (s *server) module(id int) (Module, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 0 || id >= len(s.modules) {
+		return nil, fmt.Errorf("unknown module id %d", id)
+	}
+	return s.modules[id], nil
+}
+
+func (s *server) New(req *NewRequest, resp *NewResponse) error {
+	if req.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("unsupported plugin protocol version %d, expected %d", req.ProtocolVersion, ProtocolVersion)
+	}
+	// empty params are omitted from the request, but modules expect a map
+	if req.Config.Params == nil {
+		req.Config.Params = map[string]string{}
+	}
+	m, err := s.newModule(req.Config, bytes.NewReader(req.CredsKey), bytes.NewReader(req.KeysDecrypter))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modules = append(s.modules, m)
+	*resp = NewResponse{ProtocolVersion: ProtocolVersion, ModuleID: len(s.modules) - 1}
+	return nil
+}
+
+func (s *server) Encrypt(req *CryptRequest, resp *CryptResponse) error {
+	m, err := s.module(req.ModuleID)
+	if err != nil {
+		return err
+	}
+	resp.Data, err = m.Encrypt(req.Data, req.Context)
+	return err
+}
+
+func (s *server) Decrypt(req *CryptRequest, resp *CryptResponse) error {
+	m, err := s.module(req.ModuleID)
+	if err != nil {
+		return err
+	}
+	resp.Data, err = m.Decrypt(req.Data, req.Context)
+	return err
+}
+
+func (s *server) DecryptionKeys(req *KeysRequest, resp *KeysResponse) error {
+	m, err := s.module(req.ModuleID)
+	if err != nil {
+		return err
+	}
+	keys, err := m.DecryptionKeys()
+	if err != nil {
+		return err
+	}
+	resp.Keys = make([]KeyData, len(keys))
+	for i, k := range keys {
+		js, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		resp.Keys[i] = KeyData{JSON: js, Plaintext: k.Plaintext()}
+	}
+	return nil
+}
+
+// stdio is the stdin and stdout of the plugin process, that the projector talks to
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error {
+	os.Stdin.Close()
+	return os.Stdout.Close()
+}
+
+// Serve serves the Modules made by newModule to the projector on stdin and stdout,
+// until the projector closes stdin. Plugins call it from their main, and must not
+// write anything else to stdout
+func Serve(newModule NewFunc) error {
+	return ServeConn(stdio{}, newModule)
+}
+
+// ServeConn serves the Modules made by newModule on conn, until it is closed
+func ServeConn(conn io.ReadWriteCloser, newModule NewFunc) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, &server{newModule: newModule}); err != nil {
+		return err
+	}
+	srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	return nil
+}
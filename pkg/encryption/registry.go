@@ -0,0 +1,159 @@
+package encryption
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+)
+
+// Constructor creates a Module from its config, and readers of the creds keys file and
+// the keys decrypter file. Either reader may be empty, if the file was not given
+type Constructor func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error)
+
+// Param describes a param of an encryption module, set in `$.encryption.params`. The
+// projection mapping schema is generated from the Params of the registered modules, and
+// mappings are validated against it (see pkg/schema)
+type Param struct {
+	Description string
+	Required    bool
+	// Default is the value the module uses when the param is omitted, for documentation
+	Default string
+	// Values are the permitted values, if the param has a fixed set of them
+	Values []string
+	// Pattern is a regular expression the value must match, if set
+	Pattern string
+}
+
+// Registration is an encryption module, registered by name
+type Registration struct {
+	// Name is what projection mappings select the module with, in `$.encryption.module`
+	Name string
+	New  Constructor
+	// Params are the params the module accepts, by name, and any other param is
+	// rejected, by the schema and ValidateParams. A nil Params accepts any params, for
+	// modules that check their own
+	Params map[string]Param
+	// Shutdown releases what the Modules made by New hold on to, i.e. connections or
+	// processes, when the projector is done with them, if set
+	Shutdown func() error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Registration{}
+)
+
+// Register makes an encryption module available to projection mappings by its name.
+// It is meant to be called from init functions, and panics if the name is empty or
+// taken, or the module has no Constructor
+func Register(r Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if r.Name == "" {
+		panic("encryption: Register with an empty module name")
+	}
+	if r.New == nil {
+		panic("encryption: Register of module " + r.Name + " without a constructor")
+	}
+	if _, dup := registry[r.Name]; dup {
+		panic("encryption: Register called twice for module " + r.Name)
+	}
+	registry[r.Name] = r
+}
+
+// Lookup returns the registration of the encryption module with the name
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, ok := registry[name]
+	return r, ok
+}
+
+// List returns the names of the registered encryption modules, sorted
+func List() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Shutdown shuts down all the registered encryption modules, once the projector is done
+// encrypting. It returns the first error of a module failing to shut down
+func Shutdown() error {
+	var first error
+	for _, name := range List() {
+		r, _ := Lookup(name)
+		if r.Shutdown == nil {
+			continue
+		}
+		if err := r.Shutdown(); err != nil && first == nil {
+			first = fmt.Errorf("unable to shut down encryption module %s: %s", name, err.Error())
+		}
+	}
+	return first
+}
+
+// ValidateParams checks params against the Params of the module, like the projection
+// mapping schema does: no unknown params, all required params set, and every value
+// permitted and matching its Pattern. An empty value is the same as an omitted one
+func (r Registration) ValidateParams(params map[string]string) error {
+	if r.Params == nil {
+		return nil
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, ok := r.Params[name]
+		if !ok {
+			return fmt.Errorf("unknown param %s", name)
+		}
+		v := params[name]
+		if v == "" {
+			continue
+		}
+		if len(p.Values) > 0 && !contains(p.Values, v) {
+			return fmt.Errorf("param %s must be one of %s, not %s", name, strings.Join(p.Values, ", "), v)
+		}
+		if p.Pattern != "" {
+			matched, err := regexp.MatchString(p.Pattern, v)
+			if err != nil {
+				return fmt.Errorf("invalid pattern of param %s: %s", name, err.Error())
+			}
+			if !matched {
+				return fmt.Errorf("param %s must match %s, not %s", name, p.Pattern, v)
+			}
+		}
+	}
+	required := []string{}
+	for name, p := range r.Params {
+		if p.Required && params[name] == "" {
+			required = append(required, name)
+		}
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		return fmt.Errorf("missing required params %s", strings.Join(required, ", "))
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package encryption
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
+)
+
+// rot13Shutdowns counts the Shutdowns of the rot13 module
+var rot13Shutdowns int
+
+// rot13 is a test module, registered by the tests
+type rot13 struct {
+	credsKey string
+}
+
+func (m *rot13) Encrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		switch {
+		case b >= 'a' && b <= 'z':
+			out[i] = 'a' + (b-'a'+13)%26
+		case b >= 'A' && b <= 'Z':
+			out[i] = 'A' + (b-'A'+13)%26
+		default:
+			out[i] = b
+		}
+	}
+	return out, nil
+}
+
+func (m *rot13) Decrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	return m.Encrypt(data, ctx)
+}
+
+func (m *rot13) DecryptionKeys() ([]key.Key, error) {
+	return nil, nil
+}
+
+func init() {
+	Register(Registration{
+		Name: "rot13",
+		New: func(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (Module, error) {
+			b, err := ioutil.ReadAll(credsKeyReader)
+			if err != nil {
+				return nil, err
+			}
+			return &rot13{credsKey: string(b)}, nil
+		},
+		Params: map[string]Param{
+			"rounds": {Values: []string{"1", "3"}},
+			"salt":   {Required: true},
+			"pepper": {Pattern: "^black$"},
+		},
+		Shutdown: func() error {
+			rot13Shutdowns++
+			return errors.New("rot13 is still spinning")
+		},
+	})
+}
+
+func TestList(t *testing.T) {
+	expected := []string{"cbc", "dek", "kms", "plugin", "rot13", "rsa-oaep"}
+	if names := List(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected modules %v, got %v", expected, names)
+	}
+	if _, ok := Lookup("rot13"); !ok {
+		t.Errorf("expected to look up the rot13 module")
+	}
+	if _, ok := Lookup("rot26"); ok {
+		t.Errorf("expected no rot26 module")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering the cbc module twice to panic")
+		}
+	}()
+	r, _ := Lookup("cbc")
+	Register(r)
+}
+
+func TestNewModuleFromEncryptionConfig(t *testing.T) {
+	m, err := NewModuleFromEncryptionConfig(conf.Encryption{
+		Module:            "rot13",
+		Params:            map[string]string{"salt": "sea", "rounds": "3"},
+		CredsKeysFilePath: "test/fixtures/files/aes_params_test_1.json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := m.Encrypt([]byte("Hello"), aad.Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(enc) != "Uryyb" {
+		t.Errorf("expected Uryyb, got %s", enc)
+	}
+	if m.(*rot13).credsKey == "" {
+		t.Errorf("expected the module to be passed the creds keys file")
+	}
+
+	for _, test := range []struct {
+		module      string
+		params      map[string]string
+		expectedErr string
+	}{
+		{"rot13", map[string]string{}, "missing required params salt"},
+		{"rot13", map[string]string{"salt": "sea", "sugar": "cane"}, "unknown param sugar"},
+		{"rot13", map[string]string{"salt": "sea", "rounds": "2"}, "param rounds must be one of 1, 3, not 2"},
+		{"rot13", map[string]string{"salt": "sea", "pepper": "white"}, "param pepper must match ^black$, not white"},
+		{"cbc", map[string]string{"hash": "crc32"}, "param hash must be one of md5, sha1, sha256, sha512, not crc32"},
+		{"cbc", map[string]string{"scrypt-n": "-1"}, "param scrypt-n must match " + positiveInt + ", not -1"},
+		{"kms", map[string]string{"timeout": "5s"}, "missing required params endpoint"},
+	} {
+		expectedErr := "invalid params for encryption module '" + test.module + "': " + test.expectedErr
+		_, err := NewModuleFromEncryptionConfig(conf.Encryption{Module: test.module, Params: test.params})
+		if err == nil || err.Error() != expectedErr {
+			t.Errorf("expected %s, got %v", expectedErr, err)
+		}
+	}
+
+	if _, err := NewModuleFromEncryptionConfig(conf.Encryption{Module: "rot26"}); err == nil || err.Error() != "unsupported encryption module 'rot26'" {
+		t.Errorf("expected an unsupported module error, got %v", err)
+	}
+	if _, err := NewModuleFromEncryptionConfig(conf.Encryption{Module: "plugin"}); err != ErrMissingPluginPath {
+		t.Errorf("expected %v, got %v", ErrMissingPluginPath, err)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	sealer := conf.Encryption{
+		Module:            "cbc",
+		Params:            map[string]string{"hash": "sha256"},
+		CredsKeysFilePath: "test/fixtures/files/aes_params_test_1.json",
+	}
+	m, err := NewModuleFromEncryptionConfig(sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := aad.Context{Namespace: "web", Name: "db-credentials", Key: "password"}
+	enc, err := m.Encrypt([]byte("Hello"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mapping has since moved to the dek module, and another hash
+	opener := conf.Encryption{
+		Module:            "dek",
+		Params:            map[string]string{"hash": "sha512", "scope": "key"},
+		CredsKeysFilePath: sealer.CredsKeysFilePath,
+	}
+	dec, err := Decrypt(opener, enc, ctx)
+	if err != nil {
+		t.Fatalf("expected the cbc module named by the envelope to decrypt it, got %s", err.Error())
+	}
+	if string(dec) != "Hello" {
+		t.Errorf("expected Hello, got %s", dec)
+	}
+
+	// raw ciphertext has no header, and is decrypted by the configured module
+	dec, err = Decrypt(conf.Encryption{Module: "rot13", Params: map[string]string{"salt": "sea"}}, []byte("Uryyb"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dec) != "Hello" {
+		t.Errorf("expected Hello, got %s", dec)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	expectedErr := "unable to shut down encryption module rot13: rot13 is still spinning"
+	if err := Shutdown(); err == nil || err.Error() != expectedErr {
+		t.Errorf("expected %s, got %v", expectedErr, err)
+	}
+	if rot13Shutdowns != 1 {
+		t.Errorf("expected rot13 to be shut down once, got %d", rot13Shutdowns)
+	}
+}
@@ -0,0 +1,232 @@
+package rsaoaep
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/key"
+)
+
+const (
+	// ModuleName selects the rsa-oaep module, whose envelopes list a wrapped data key per recipient
+	ModuleName = "rsa-oaep"
+
+	// minKeyBits is the smallest RSA key we encrypt to
+	minKeyBits = 2048
+	// dataKeyLength is the size of data keys, for AES-256
+	dataKeyLength = 32
+)
+
+var (
+	// ErrNoRecipients is returned when the rsa-oaep module is configured without recipients to encrypt to
+	ErrNoRecipients = fmt.Errorf("the %s encryption module needs at least one recipient", ModuleName)
+	// ErrNoIdentity is returned when decrypting without a private key
+	ErrNoIdentity = errors.New("no private key to decrypt with")
+	// ErrNotARecipient is returned when decrypting an envelope that was not encrypted to the private key
+	ErrNotARecipient = errors.New("envelope was not encrypted to this private key")
+)
+
+// Crypter encrypts to the public keys of its recipients: each item is encrypted with
+// a random data key, which is wrapped with RSA-OAEP for every recipient. The projector
+// only needs the public keys; decrypting needs the private key of a recipient
+type Crypter struct {
+	c          conf.Encryption
+	recipients []recipient
+	// identity is the private key to decrypt with, if any
+	identity   *rsa.PrivateKey
+	identityID string
+}
+
+type recipient struct {
+	id  string
+	pub *rsa.PublicKey
+}
+
+// New creates a new Crypter encryption module, encrypting to the c.Recipients. If
+// identityReader has a PEM encoded RSA private key, the Crypter can also decrypt with it
+func New(c conf.Encryption, identityReader io.Reader) (*Crypter, error) {
+	if err := envelope.RequireHeader(c.Format); err != nil {
+		return nil, err
+	}
+	if len(c.Recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	m := Crypter{c: c}
+	for i, r := range c.Recipients {
+		pub, err := parsePublicKey([]byte(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %d: %s", i+1, err.Error())
+		}
+		id, err := KeyID(pub)
+		if err != nil {
+			return nil, err
+		}
+		m.recipients = append(m.recipients, recipient{id: id, pub: pub})
+	}
+
+	if identityReader == nil {
+		return &m, nil
+	}
+	raw, err := ioutil.ReadAll(identityReader)
+	if err != nil {
+		return nil, err
+	}
+	// the creds keys file is shared by all mappings, so without a PEM block it is
+	// likely the key file of another module, and there is no identity
+	if !strings.Contains(string(raw), "-----BEGIN ") {
+		return &m, nil
+	}
+	m.identity, err = parsePrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %s", err.Error())
+	}
+	m.identityID, err = KeyID(&m.identity.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// KeyID identifies a public key, by a fingerprint of its PKIX encoding
+func KeyID(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// parsePublicKey reads a PEM encoded PKIX ("PUBLIC KEY") or PKCS#1 ("RSA PUBLIC KEY") RSA public key
+func parsePublicKey(raw []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key")
+	}
+	var pub *rsa.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if pub, ok = k.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("unsupported public key type %T", k)
+		}
+	case "RSA PUBLIC KEY":
+		var err error
+		if pub, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if pub.N.BitLen() < minKeyBits {
+		return nil, fmt.Errorf("RSA keys must be at least %d bits, got %d", minKeyBits, pub.N.BitLen())
+	}
+	return pub, nil
+}
+
+// parsePrivateKey reads a PEM encoded PKCS#8 ("PRIVATE KEY") or PKCS#1 ("RSA PRIVATE KEY") RSA private key
+func parsePrivateKey(raw []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", k)
+		}
+		return priv, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+}
+
+// Encrypt some bytes with a random data key, wrapped for each recipient. The data
+// and the wrapped data keys are bound to ctx
+func (c *Crypter) Encrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	k := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return nil, err
+	}
+	aead, err := envelope.NewAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	h := envelope.Header{
+		Module: ModuleName,
+		Params: map[string]string{"cipher": "aes", "hash": "sha256"},
+		Nonce:  nonce,
+		Bound:  true,
+	}
+	for _, r := range c.recipients {
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.pub, k, ctx.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		h.Recipients = append(h.Recipients, envelope.Recipient{KeyID: r.id, WrappedKey: wrapped})
+	}
+	return envelope.Marshal(h, aead.Seal(nil, nonce, data, ctx.Bytes()), c.c.Format == envelope.FormatArmor)
+}
+
+// Decrypt some bytes found in ctx with the private key, if it is one of the envelope's recipients
+func (c *Crypter) Decrypt(data []byte, ctx aad.Context) ([]byte, error) {
+	if c.identity == nil {
+		return nil, ErrNoIdentity
+	}
+	h, ciphertext, err := envelope.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.Module != ModuleName {
+		return nil, fmt.Errorf("envelope was sealed by encryption module %s, not %s", h.Module, ModuleName)
+	}
+	for _, r := range h.Recipients {
+		if r.KeyID != c.identityID {
+			continue
+		}
+		k, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, c.identity, r.WrappedKey, ctx.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("unable to unwrap data key: %s", err.Error())
+		}
+		aead, err := envelope.NewAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		if len(h.Nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("envelope nonce is %d bytes, expected %d", len(h.Nonce), aead.NonceSize())
+		}
+		return aead.Open(nil, h.Nonce, ciphertext, ctx.Bytes())
+	}
+	return nil, ErrNotARecipient
+}
+
+// DecryptionKeys returns no keys: only the recipients hold the private keys to decrypt with
+func (c *Crypter) DecryptionKeys() ([]key.Key, error) {
+	return nil, nil
+}
@@ -0,0 +1,105 @@
+package rsaoaep
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	testutil "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/envelope"
+)
+
+type testIdentity struct {
+	private string
+	public  string
+}
+
+func newTestIdentity(t *testing.T, bits int) testIdentity {
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testIdentity{
+		private: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})),
+		public:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+	}
+}
+
+func newConfig(recipients ...testIdentity) conf.Encryption {
+	c := conf.Encryption{Module: ModuleName}
+	for _, r := range recipients {
+		c.Recipients = append(c.Recipients, r.public)
+	}
+	return c
+}
+
+func TestRecipients(t *testing.T) {
+	alice, bob, eve := newTestIdentity(t, 2048), newTestIdentity(t, 2048), newTestIdentity(t, 2048)
+
+	// the projector only has the public keys
+	projector, err := New(newConfig(alice, bob), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := projector.DecryptionKeys()
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected no decryption keys, got %v (%v)", keys, err)
+	}
+	for _, recipient := range []testIdentity{alice, bob} {
+		c, err := New(newConfig(alice, bob), strings.NewReader(recipient.private))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.RoundTrip(t, projector, c)
+	}
+
+	enc, err := projector.Encrypt([]byte(testutil.SomeData), testutil.Context1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := projector.Decrypt(enc, testutil.Context1); err != ErrNoIdentity {
+		t.Errorf("expected %v decrypting without a private key, got %v", ErrNoIdentity, err)
+	}
+	c, err := New(newConfig(alice, bob), strings.NewReader(eve.private))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decrypt(enc, testutil.Context1); err != ErrNotARecipient {
+		t.Errorf("expected %v, got %v", ErrNotARecipient, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(newConfig(), nil); err != ErrNoRecipients {
+		t.Errorf("expected %v, got %v", ErrNoRecipients, err)
+	}
+	small := newTestIdentity(t, 1024)
+	if _, err := New(newConfig(small), nil); err == nil || err.Error() != "invalid recipient 1: RSA keys must be at least 2048 bits, got 1024" {
+		t.Errorf("expected a key size error, got %v", err)
+	}
+	alice := newTestIdentity(t, 2048)
+	cfg := newConfig(alice)
+	cfg.Format = envelope.FormatRaw
+	if _, err := New(cfg, nil); err != envelope.ErrRawFormat {
+		t.Errorf("expected %v, got %v", envelope.ErrRawFormat, err)
+	}
+	if _, err := New(newConfig(alice), strings.NewReader(alice.public)); err == nil || err.Error() != "invalid private key: unsupported PEM block PUBLIC KEY" {
+		t.Errorf("expected a private key error, got %v", err)
+	}
+	// key files of other modules are not an identity
+	c, err := New(newConfig(alice), strings.NewReader(`{"password":"elloOliv3R!420"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.identity != nil {
+		t.Errorf("expected no identity from a cbc key file")
+	}
+}
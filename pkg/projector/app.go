@@ -3,15 +3,13 @@ package projector
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
 	"github.com/tumblr/k8s-secret-projector/pkg/types"
-	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
 )
 
 var (
@@ -20,17 +18,25 @@ var (
 
 type app struct {
 	conf.Config
+	// stdin is where projection mappings are read from, when the projection mappings root path is "-"
+	stdin io.Reader
 }
 
 // App is the thing that does the needful
 type App interface {
 	LoadProjectionMappings() ([]types.ProjectionMapping, error)
+	ChangedCreds() (map[string][]string, error)
+	FilterChangedProjectionMappings([]types.ProjectionMapping) ([]types.ProjectionMapping, error)
+	// Close shuts down the encryption modules of the loaded projection mappings, i.e.
+	// closes their connections to a KMS and stops their plugins, once the App is done projecting
+	Close() error
 }
 
 // New returns a new App
 func New(c conf.Config) App {
 	return &app{
 		c,
+		os.Stdin,
 	}
 }
 
@@ -38,35 +44,38 @@ func New(c conf.Config) App {
 func (a *app) LoadProjectionMappings() ([]types.ProjectionMapping, error) {
 	projectionMappings := []types.ProjectionMapping{}
 	errs := []error{}
-	filepath.Walk(a.ProjectionMappingsRootPath(), func(path string, info os.FileInfo, err error) error {
-		// for each path, test that is is a yaml file, and load it
-		if info == nil || info.IsDir() {
-			return nil
-		}
-		// test for *.yaml suffix
-		if !strings.HasSuffix(info.Name(), ".yaml") {
-			// skip this file
-			return nil
-		}
-
-		raw, err := ioutil.ReadFile(path)
-		if err != nil {
-			log.Printf("Error reading projection mapping %s: %s\n", path, err.Error())
+	docs, err := a.readProjectionMappingDocuments()
+	if err != nil {
+		return projectionMappings, err
+	}
+	defaults, defaultsErrs := collectDefaults(docs)
+	docs, extendsErrs := resolveExtends(docs)
+	docs, applyErrs := applyDefaults(defaults, docs)
+	for _, docErrs := range []map[string]error{defaultsErrs, extendsErrs, applyErrs} {
+		for source, err := range docErrs {
+			log.Printf("Error loading projection mapping %s: %s\n", source, err.Error())
 			errs = append(errs, err)
-			return err
 		}
-		m, err := v1.LoadFromYamlBytes(raw, a.Config)
+	}
+	for _, doc := range docs {
+		m, err := LoadProjectionMapping(doc.raw, a.Config)
 		if err != nil {
-			log.Printf("Error loading projection mapping %s: %s\n", path, err.Error())
+			log.Printf("Error loading projection mapping %s: %s\n", doc.source, err.Error())
 			errs = append(errs, err)
-			return err
+			continue
 		}
 		if a.Debug() {
 			log.Printf("Loaded projection mapping: %s\n", m)
 		}
+		if denials := a.checkAccessPolicy(m); len(denials) > 0 {
+			for _, d := range denials {
+				log.Printf("Access denied: projection mapping %s %s\n", doc.source, d)
+			}
+			errs = append(errs, fmt.Errorf("projection mapping %s denied access to %d creds files", doc.source, len(denials)))
+			continue
+		}
 		projectionMappings = append(projectionMappings, m)
-		return nil
-	})
+	}
 
 	if len(errs) > 0 {
 		return projectionMappings, fmt.Errorf("unable to load %d projection mappings", len(errs))
@@ -74,3 +83,26 @@ func (a *app) LoadProjectionMappings() ([]types.ProjectionMapping, error) {
 
 	return projectionMappings, nil
 }
+
+// Close shuts down the encryption modules
+func (a *app) Close() error {
+	return encryption.Shutdown()
+}
+
+// checkAccessPolicy returns a description of each source in m that the configured
+// AccessPolicy does not grant one of m's namespaces. No policy means everything is allowed.
+func (a *app) checkAccessPolicy(m types.ProjectionMapping) []string {
+	policy := a.AccessPolicy()
+	if policy == nil {
+		return nil
+	}
+	denials := []string{}
+	for _, ns := range m.GetNamespaces() {
+		for _, ref := range m.GetSourceReferences() {
+			if !policy.Allows(ns, ref.Repo, ref.Path) {
+				denials = append(denials, fmt.Sprintf("(namespace %s) may not use %s:%s (data item %s)", ns, ref.Repo, ref.Path, ref.Key))
+			}
+		}
+	}
+	return denials
+}
@@ -0,0 +1,114 @@
+package projector
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+)
+
+// ChangedCreds returns the creds files that changed, keyed by creds repo. These are
+// the files passed with --changed-creds, plus the files changed between the git revisions
+// passed with --changed-creds-revs. Returns nil if neither flag was given.
+func (a *app) ChangedCreds() (map[string][]string, error) {
+	if len(a.Config.ChangedCredsFiles()) == 0 && len(a.ChangedCredsRevisions()) == 0 {
+		return nil, nil
+	}
+	changed := map[string][]string{}
+	for repo, files := range a.Config.ChangedCredsFiles() {
+		changed[repo] = append(changed[repo], files...)
+	}
+	for repo, revs := range a.ChangedCredsRevisions() {
+		credsPath, err := a.CredsRootPath(repo)
+		if err != nil {
+			return nil, err
+		}
+		r := strings.SplitN(revs, "..", 2)
+		files, err := gitChangedFiles(credsPath, r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		changed[repo] = append(changed[repo], files...)
+	}
+	return changed, nil
+}
+
+// FilterChangedProjectionMappings returns only the mappings that project a changed creds
+// file (see ChangedCreds). If no changes were specified, all mappings are returned.
+func (a *app) FilterChangedProjectionMappings(mappings []types.ProjectionMapping) ([]types.ProjectionMapping, error) {
+	changed, err := a.ChangedCreds()
+	if err != nil {
+		return nil, err
+	}
+	if changed == nil {
+		return mappings, nil
+	}
+	return FilterMappingsBySources(mappings, changed), nil
+}
+
+// FilterMappingsBySources returns the mappings that reference any of the given creds
+// files, keyed by creds repo, in any of their data sources
+func FilterMappingsBySources(mappings []types.ProjectionMapping, files map[string][]string) []types.ProjectionMapping {
+	index := map[string]map[string]bool{}
+	for repo, fs := range files {
+		index[repo] = map[string]bool{}
+		for _, f := range fs {
+			index[repo][cleanCredsPath(f)] = true
+		}
+	}
+	filtered := []types.ProjectionMapping{}
+	for _, m := range mappings {
+		for _, ref := range m.GetSourceReferences() {
+			if index[ref.Repo][cleanCredsPath(ref.Path)] {
+				filtered = append(filtered, m)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func cleanCredsPath(p string) string {
+	return path.Clean(filepath.ToSlash(p))
+}
+
+// gitChangedFiles lists the files changed between two revisions of the git repo at
+// credsPath, relative to credsPath. Renames are reported as both the old and new path.
+// credsPath may also be a git revision spec (see creds.IsRevisionSpec), in which case
+// its repository is diffed. The revisions are resolved to commits first, so they are
+// never taken for options of git diff.
+func gitChangedFiles(credsPath string, from string, to string) ([]string, error) {
+	if repo, _, ok := creds.SplitRevisionSpec(credsPath); ok {
+		credsPath = repo
+	}
+	commits := make([]string, 2)
+	for i, rev := range []string{from, to} {
+		if strings.HasPrefix(rev, "-") {
+			return nil, fmt.Errorf("invalid git revision %s in %s", rev, credsPath)
+		}
+		g, err := creds.NewGitRevision(credsPath, rev)
+		if err != nil {
+			return nil, err
+		}
+		commits[i] = g.Commit()
+	}
+	cmd := exec.Command("git", "-C", credsPath, "diff", "--name-only", "--no-renames", "--relative", commits[0], commits[1], "--")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff %s..%s in %s: %s: %s", from, to, credsPath, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	files := []string{}
+	for _, f := range strings.Split(string(out), "\n") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,108 @@
+package projector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	testutil "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+)
+
+var (
+	testMappings = []types.ProjectionMapping{
+		&v1.ProjectionMapping{
+			Name:      "test1",
+			Namespace: "json-tests",
+			Repo:      "production",
+			Data: []v1.Secret{
+				{Name: "single-json-key", Source: v1.DataSource{JSON: "object1.json", JSONPath: "$.secret"}},
+			},
+		},
+		&v1.ProjectionMapping{
+			Name:      "test2",
+			Namespace: "raw-test1",
+			Repo:      "production",
+			Data: []v1.Secret{
+				{Name: "raw-file", Source: v1.DataSource{Raw: "nested/raw1.txt"}},
+			},
+		},
+		&v1.ProjectionMapping{
+			Name:      "test3",
+			Namespace: "raw-test1",
+			Repo:      "staging",
+			Data: []v1.Secret{
+				{Name: "raw-file", Source: v1.DataSource{Raw: "nested/raw1.txt"}},
+			},
+		},
+	}
+)
+
+func TestFilterMappingsBySources(t *testing.T) {
+	tests := []struct {
+		changed  map[string][]string
+		expected []string
+	}{
+		{map[string][]string{"production": {"object1.json"}}, []string{"test1"}},
+		{map[string][]string{"production": {"./nested/raw1.txt", "unused.txt"}}, []string{"test2"}},
+		{map[string][]string{"production": {"nested/raw1.txt"}, "staging": {"nested/raw1.txt"}}, []string{"test2", "test3"}},
+		{map[string][]string{"development": {"object1.json"}}, []string{}},
+	}
+	for _, tst := range tests {
+		filtered := FilterMappingsBySources(testMappings, tst.changed)
+		names := []string{}
+		for _, m := range filtered {
+			names = append(names, m.GetName())
+		}
+		if len(names) != len(tst.expected) {
+			t.Fatalf("expected changes %v to affect %v, but got %v", tst.changed, tst.expected, names)
+		}
+		for i := range names {
+			if names[i] != tst.expected[i] {
+				t.Fatalf("expected changes %v to affect %v, but got %v", tst.changed, tst.expected, names)
+			}
+		}
+	}
+}
+
+func TestGitChangedFiles(t *testing.T) {
+	dir := testutil.GitRepo(t,
+		map[string]string{"a.txt": "a", "nested/b.txt": "b"},
+		map[string]string{"nested/b.txt": "b2", "c.txt": "c"},
+	)
+	defer os.RemoveAll(dir)
+
+	files, err := gitChangedFiles(dir, "HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"c.txt", "nested/b.txt"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected changed files %v, got %v", expected, files)
+	}
+	files, err = gitChangedFiles(filepath.Join(dir, "nested"), "HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"b.txt"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected changed files relative to the creds path %v, got %v", expected, files)
+	}
+
+	// revisions are never taken for options of git diff
+	out, err := ioutil.TempDir("", "changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	output := filepath.Join(out, "diff.txt")
+	for _, revs := range [][2]string{{"--output=" + output, "HEAD"}, {"HEAD~1", "--output=" + output}, {"HEAD~1", "nonexistent"}} {
+		if _, err := gitChangedFiles(dir, revs[0], revs[1]); err == nil {
+			t.Errorf("expected an error diffing %s..%s", revs[0], revs[1])
+		}
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("expected git diff not to write %s", output)
+	}
+}
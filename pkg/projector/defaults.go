@@ -0,0 +1,130 @@
+package projector
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultsFile holds defaults for every projection mapping in its directory and below
+	DefaultsFile = TemplatePrefix + "defaults.yaml"
+)
+
+var (
+	// defaultsFields are the fields a DefaultsFile may set
+	defaultsFields = []string{"repo", "namespace", "encryption", "labels"}
+)
+
+// mappingDefaults are the contents of each DefaultsFile, keyed by its directory
+type mappingDefaults map[string]yaml.MapSlice
+
+// collectDefaults reads the DefaultsFile documents out of docs. A DefaultsFile that
+// cant be read is returned as an error, keyed by its source.
+func collectDefaults(docs []mappingDocument) (mappingDefaults, map[string]error) {
+	defaults := mappingDefaults{}
+	errs := map[string]error{}
+	for _, doc := range docs {
+		if doc.file == "" || path.Base(doc.file) != DefaultsFile {
+			continue
+		}
+		if doc.documents != 1 {
+			errs[doc.source] = fmt.Errorf("%s must contain a single document", DefaultsFile)
+			continue
+		}
+		// defaults apply to every apiVersion, and the latest describes all their fields
+		d, err := parseMapping(doc.raw, types.APIVersionV2)
+		if err != nil {
+			errs[doc.source] = err
+			continue
+		}
+		if err := checkDefaultsFields(d); err != nil {
+			errs[doc.source] = err
+			continue
+		}
+		defaults[path.Dir(doc.file)] = d
+	}
+	return defaults, errs
+}
+
+// checkDefaultsFields returns an error if d sets a field that isnt in defaultsFields
+func checkDefaultsFields(d yaml.MapSlice) error {
+	for _, item := range d {
+		if !isDefaultsField(fmt.Sprint(item.Key)) {
+			return fmt.Errorf("%s may not set %v, only %s", DefaultsFile, item.Key, strings.Join(defaultsFields, ", "))
+		}
+	}
+	return nil
+}
+
+func isDefaultsField(name string) bool {
+	for _, f := range defaultsFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// forFile returns the defaults for a projection mapping file, merged from the root
+// of the projection mappings down to the file's directory, so nearer defaults win
+func (d mappingDefaults) forFile(file string) yaml.MapSlice {
+	dirs := []string{}
+	for dir := path.Dir(file); ; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == "." || dir == "/" {
+			break
+		}
+	}
+	merged := yaml.MapSlice{}
+	for _, dir := range dirs {
+		if defaults, ok := d[dir]; ok {
+			merged = mergeMapSlices(merged, defaults, nil)
+		}
+	}
+	return merged
+}
+
+// applyDefaults overlays each document on the defaults for its file. Fields the document
+// sets win, and a document can opt out of a default by setting it to null. Like with extends,
+// an encryption of another module replaces the default encryption, rather than being merged
+// with it (see mergeMapSlices). namespace doesnt apply to a document that fans out to
+// namespaces. DefaultsFile documents are dropped.
+func applyDefaults(defaults mappingDefaults, docs []mappingDocument) ([]mappingDocument, map[string]error) {
+	out := []mappingDocument{}
+	errs := map[string]error{}
+	for _, doc := range docs {
+		if doc.file != "" && path.Base(doc.file) == DefaultsFile {
+			// defaults are never projected as a mapping
+			continue
+		}
+		if doc.file == "" || len(defaults) == 0 {
+			out = append(out, doc)
+			continue
+		}
+		d := defaults.forFile(doc.file)
+		if len(d) == 0 {
+			out = append(out, doc)
+			continue
+		}
+		m, err := parseMapping(doc.raw, "")
+		if err != nil {
+			errs[doc.source] = err
+			continue
+		}
+		if _, ok := mapSliceGet(m, "namespaces"); ok {
+			d = mapSliceDelete(d, "namespace")
+		}
+		raw, err := yaml.Marshal(mergeMapSlices(d, m, nil))
+		if err != nil {
+			errs[doc.source] = err
+			continue
+		}
+		doc.raw = raw
+		out = append(out, doc)
+	}
+	return out, errs
+}
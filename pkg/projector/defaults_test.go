@@ -0,0 +1,138 @@
+package projector
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadProjectionMappingsWithDefaults(t *testing.T) {
+	c, err := conf.LoadConfigFromArgs([]string{os.Args[0],
+		"-creds-repo=production=test/fixtures/files",
+		"-manifests=test/fixtures/manifests-defaults",
+		"-creds-encryption-key=test/fixtures/files/encryption-cbc-key.json",
+		"-label-secrets=false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappings, err := New(c).LoadProjectionMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := map[string]types.ProjectionMapping{}
+	for _, m := range mappings {
+		loaded[m.GetName()] = m
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("expected 3 projection mappings (and no _defaults.yaml), got %v", mappings)
+	}
+
+	if m := loaded["root"]; m.GetRepo() != "production" || m.GetNamespace() != "json-tests" || m.GetEncryptionConfig().Module != "" {
+		t.Errorf("expected root to get only the root defaults, got %s %+v", m.String(), m.GetEncryptionConfig())
+	}
+
+	v1 := loaded["v1"]
+	if v1.GetRepo() != "production" || v1.GetNamespace() != "team-x" {
+		t.Errorf("expected v1 to get the repo from the root defaults, and namespace from team-x, got %s", v1.String())
+	}
+	if e := v1.GetEncryptionConfig(); e.Module != "cbc" || e.Params["hash"] != "sha256" {
+		t.Errorf("expected v1 to get the team-x encryption, got %+v", e)
+	}
+
+	v2 := loaded["v2"]
+	if v2.GetNamespace() != "elsewhere" || v2.GetEncryptionConfig().Module != "" {
+		t.Errorf("expected v2 to override the namespace and opt out of encryption, got %s %+v", v2.String(), v2.GetEncryptionConfig())
+	}
+	fsys, err := creds.NewDir("test/fixtures/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := v2.ProjectSecret(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := map[string]string{"team": "x", "tier": "frontend"}
+	if !reflect.DeepEqual(secret.Labels, expectedLabels) {
+		t.Errorf("expected v2 labels %v, got %v", expectedLabels, secret.Labels)
+	}
+}
+
+func TestDefaultsFields(t *testing.T) {
+	docs := []mappingDocument{
+		{source: "a/_defaults.yaml", file: "a/_defaults.yaml", raw: []byte("repo: production\ndata: []\n"), documents: 1},
+	}
+	defaults, errs := collectDefaults(docs)
+	if err := errs["a/_defaults.yaml"]; err == nil || !strings.Contains(err.Error(), "may not set data") {
+		t.Errorf("expected an error setting data in defaults, got %v", err)
+	}
+	if d, ok := defaults["a"]; ok {
+		t.Errorf("expected invalid defaults not to be applied, got %v", d)
+	}
+}
+
+func TestDefaultsNamespaceSkippedForFanOut(t *testing.T) {
+	defaults := mappingDefaults{".": {{Key: "namespace", Value: "ns"}, {Key: "repo", Value: "production"}}}
+	docs, errs := applyDefaults(defaults, []mappingDocument{
+		{source: "a.yaml", file: "a.yaml", raw: []byte("name: a\nnamespaces: [x, y]\n"), documents: 1},
+	})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	// y is a namespace, not a yaml 1.1 boolean
+	if string(docs[0].raw) != "repo: production\nname: a\nnamespaces:\n- x\n- \"y\"\n" {
+		t.Errorf("unexpected mapping with defaults applied:\n%s", docs[0].raw)
+	}
+}
+
+func TestDefaultsEncryptionOfAnotherModule(t *testing.T) {
+	defaults := mappingDefaults{
+		".":      {{Key: "encryption", Value: yaml.MapSlice{{Key: "module", Value: "cbc"}, {Key: "params", Value: yaml.MapSlice{{Key: "hash", Value: "sha256"}}}}}},
+		"team-x": {{Key: "encryption", Value: yaml.MapSlice{{Key: "module", Value: "rsa-oaep"}}}},
+	}
+	for _, tc := range []struct {
+		file     string
+		raw      string
+		expected string
+	}{
+		{"a.yaml", "name: a\nencryption:\n  params:\n    kdf: scrypt\n", "encryption:\n  module: cbc\n  params:\n    hash: sha256\n    kdf: scrypt\nname: a\n"},
+		{"a.yaml", "name: a\nencryption:\n  module: kms\n  params:\n    endpoint: unix:///kms.sock\n", "encryption:\n  module: kms\n  params:\n    endpoint: unix:///kms.sock\nname: a\n"},
+		// nested defaults of another module replace those of their parents
+		{"team-x/a.yaml", "name: a\n", "encryption:\n  module: rsa-oaep\nname: a\n"},
+	} {
+		docs, errs := applyDefaults(defaults, []mappingDocument{{source: tc.file, file: tc.file, raw: []byte(tc.raw), documents: 1}})
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if string(docs[0].raw) != tc.expected {
+			t.Errorf("expected\n%s\napplying defaults to %s, got\n%s", tc.expected, tc.file, docs[0].raw)
+		}
+	}
+}
+
+func TestParseMappingKeepsStringText(t *testing.T) {
+	m, err := parseMapping([]byte("name: on\nnamespace: 0123\nencryption: null\nlabels: {}\ndata:\n- name: y\n  encrypt: yes\n  source:\n    raw: no\n"), types.APIVersionV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := yaml.MapSlice{
+		{Key: "name", Value: "on"},
+		{Key: "namespace", Value: "0123"},
+		{Key: "encryption", Value: nil},
+		{Key: "labels", Value: yaml.MapSlice{}},
+		{Key: "data", Value: []interface{}{yaml.MapSlice{
+			{Key: "name", Value: "y"},
+			{Key: "encrypt", Value: true},
+			{Key: "source", Value: yaml.MapSlice{{Key: "raw", Value: "no"}}},
+		}}},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected %#v, got %#v", expected, m)
+	}
+}
@@ -0,0 +1,343 @@
+package projector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// extendsKey names the projection mapping a document extends, relative to its own file
+	extendsKey = "extends"
+	// removeKey on a data item removes the item of the same name inherited from the base
+	removeKey = "remove"
+	// encryptionKey is merged only when the overlay keeps the base's encryption module
+	encryptionKey = "encryption"
+	// TemplatePrefix starts the names of projection mapping files that, when extended, are only
+	// extended and never projected
+	TemplatePrefix = "_"
+)
+
+var (
+	// ErrExtendsWithoutFile is returned when a document that was not read from a file extends another
+	ErrExtendsWithoutFile = errors.New("only projection mappings read from files may use extends")
+	// ErrRemoveWithoutExtends is returned when a data item is removed from a mapping that extends nothing
+	ErrRemoveWithoutExtends = errors.New("data items may only be removed by a mapping that extends another")
+)
+
+// isTemplate returns true if file may hold a projection mapping that is only extended, never
+// projected. It is a template only if some projection mapping extends it.
+func isTemplate(file string) bool {
+	return strings.HasPrefix(path.Base(file), TemplatePrefix)
+}
+
+// IsPartialMapping returns true if m, as written in file, may leave out fields it inherits:
+// it extends another mapping, or may be a template that another mapping extends
+func IsPartialMapping(file string, m yaml.MapSlice) bool {
+	_, extends := mapSliceGet(m, extendsKey)
+	return extends || isTemplate(file)
+}
+
+// extendsResolver merges documents with the documents they extend, memoizing the result for each file
+type extendsResolver struct {
+	files    map[string][]mappingDocument
+	resolved map[string][]byte
+	// extended are the files extended by another
+	extended map[string]bool
+}
+
+// resolveExtends merges every document that extends another with its base, and drops
+// templates: files named like templates that another document extends. A template
+// that nothing extends is projected like any other mapping. A document that cant be
+// resolved is returned as an error, keyed by its source.
+func resolveExtends(docs []mappingDocument) ([]mappingDocument, map[string]error) {
+	r := extendsResolver{
+		files:    map[string][]mappingDocument{},
+		resolved: map[string][]byte{},
+		extended: map[string]bool{},
+	}
+	for _, doc := range docs {
+		if doc.file != "" {
+			r.files[doc.file] = append(r.files[doc.file], doc)
+		}
+	}
+	// every document is resolved before any is dropped, as only then are all the templates known
+	raws := make([][]byte, len(docs))
+	resolveErrs := make([]error, len(docs))
+	for i, doc := range docs {
+		raws[i], resolveErrs[i] = r.resolve(doc, nil)
+	}
+	out := []mappingDocument{}
+	errs := map[string]error{}
+	for i, doc := range docs {
+		if doc.file != "" && isTemplate(doc.file) && r.extended[doc.file] {
+			// errors in a template are reported by the documents extending it
+			continue
+		}
+		if resolveErrs[i] != nil {
+			errs[doc.source] = resolveErrs[i]
+			continue
+		}
+		doc.raw = raws[i]
+		out = append(out, doc)
+	}
+	return out, errs
+}
+
+// resolve returns doc merged with the chain of documents it extends. chain is the files
+// being resolved that led to doc, to detect cycles.
+func (r *extendsResolver) resolve(doc mappingDocument, chain []string) ([]byte, error) {
+	if raw, ok := r.resolved[doc.file]; ok && doc.documents == 1 {
+		return raw, nil
+	}
+	m, err := parseMapping(doc.raw, "")
+	if err != nil {
+		return nil, err
+	}
+	extends, ok := mapSliceGet(m, extendsKey)
+	if !ok {
+		if hasRemovedData(m) {
+			return nil, ErrRemoveWithoutExtends
+		}
+		return doc.raw, nil
+	}
+	if doc.file == "" {
+		return nil, ErrExtendsWithoutFile
+	}
+	baseFile, err := r.baseFile(doc.file, fmt.Sprint(extends))
+	if err != nil {
+		return nil, err
+	}
+	r.extended[baseFile] = true
+	chain = append(chain, doc.file)
+	for _, f := range chain {
+		if f == baseFile {
+			return nil, fmt.Errorf("extends cycle: %s -> %s", strings.Join(chain, " -> "), baseFile)
+		}
+	}
+	baseDocs := r.files[baseFile]
+	if len(baseDocs) != 1 {
+		return nil, fmt.Errorf("extends %s: base must contain exactly 1 projection mapping, but has %d", baseFile, len(baseDocs))
+	}
+	baseRaw, err := r.resolve(baseDocs[0], chain)
+	if err != nil {
+		return nil, fmt.Errorf("extends %s: %s", baseFile, err.Error())
+	}
+	base, err := parseMapping(baseRaw, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSameAPIVersion(base, m); err != nil {
+		return nil, fmt.Errorf("extends %s: %s", baseFile, err.Error())
+	}
+	merged, err := mergeMappings(base, m)
+	if err != nil {
+		return nil, fmt.Errorf("extends %s: %s", baseFile, err.Error())
+	}
+	raw, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	if doc.documents == 1 {
+		r.resolved[doc.file] = raw
+	}
+	return raw, nil
+}
+
+// baseFile returns the file a document in file extends, relative to the projection mappings root
+func (r *extendsResolver) baseFile(file string, extends string) (string, error) {
+	if path.IsAbs(extends) {
+		return "", fmt.Errorf("extends %s: must be relative to %s", extends, file)
+	}
+	base := path.Join(path.Dir(file), extends)
+	if base == ".." || strings.HasPrefix(base, "../") {
+		return "", fmt.Errorf("extends %s: outside of the projection mappings root", extends)
+	}
+	if _, ok := r.files[base]; !ok {
+		return "", fmt.Errorf("extends %s: no such projection mapping file %s", extends, base)
+	}
+	return base, nil
+}
+
+// checkSameAPIVersion returns an error if a document extends a base of another apiVersion
+func checkSameAPIVersion(base yaml.MapSlice, m yaml.MapSlice) error {
+	baseVersion, version := apiVersionOf(base), apiVersionOf(m)
+	if baseVersion != version {
+		return fmt.Errorf("apiVersion %s does not match the base apiVersion %s", version, baseVersion)
+	}
+	return nil
+}
+
+// apiVersionOf returns the apiVersion of m, defaulting to v1 which predates versioning
+func apiVersionOf(m yaml.MapSlice) string {
+	if v, ok := mapSliceGet(m, "apiVersion"); ok && v != nil && v != "" {
+		return fmt.Sprint(v)
+	}
+	return types.APIVersionV1
+}
+
+// mergeMappings overlays m on its base. Fields of m replace those of the base, with
+// namespace and namespaces replacing each other, except:
+// maps (i.e. labels) are merged recursively, with a null value removing the base's key;
+// encryption is merged the same way if it keeps the base's module, but replaced if it
+// selects another module, as params of one module mean nothing to another; and data
+// items are merged by name, replacing the base item of the same name, or removing it if
+// the item sets remove: true.
+func mergeMappings(base yaml.MapSlice, m yaml.MapSlice) (yaml.MapSlice, error) {
+	// a mapping sets one of namespace or namespaces, so setting either replaces both
+	if _, ok := mapSliceGet(m, "namespaces"); ok {
+		base = mapSliceDelete(base, "namespace")
+	}
+	if _, ok := mapSliceGet(m, "namespace"); ok {
+		base = mapSliceDelete(base, "namespaces")
+	}
+	merged := mergeMapSlices(base, m, map[string]bool{extendsKey: true, "data": true})
+	baseData, _ := mapSliceGet(base, "data")
+	data, ok := mapSliceGet(m, "data")
+	if !ok {
+		if baseData != nil {
+			merged = mapSliceSet(merged, "data", baseData)
+		}
+		return merged, nil
+	}
+	mergedData, err := mergeData(baseData, data)
+	if err != nil {
+		return nil, err
+	}
+	return mapSliceSet(merged, "data", mergedData), nil
+}
+
+// mergeMapSlices overlays m on base, recursively merging maps and deleting keys set to null.
+// Keys in skip are not copied from either. An encryption that selects another module than
+// the base's replaces it, rather than being merged.
+func mergeMapSlices(base yaml.MapSlice, m yaml.MapSlice, skip map[string]bool) yaml.MapSlice {
+	merged := yaml.MapSlice{}
+	for _, item := range base {
+		if !skip[fmt.Sprint(item.Key)] {
+			merged = append(merged, item)
+		}
+	}
+	for _, item := range m {
+		key := fmt.Sprint(item.Key)
+		if skip[key] {
+			continue
+		}
+		if item.Value == nil {
+			merged = mapSliceDelete(merged, key)
+			continue
+		}
+		baseValue, _ := mapSliceGet(merged, key)
+		baseMap, baseIsMap := baseValue.(yaml.MapSlice)
+		valueMap, valueIsMap := item.Value.(yaml.MapSlice)
+		if baseIsMap && valueIsMap && (key != encryptionKey || sameModule(baseMap, valueMap)) {
+			merged = mapSliceSet(merged, key, mergeMapSlices(baseMap, valueMap, nil))
+			continue
+		}
+		merged = mapSliceSet(merged, key, item.Value)
+	}
+	return merged
+}
+
+// sameModule returns true if the encryption m keeps the module of base, by naming the
+// same one, or none
+func sameModule(base yaml.MapSlice, m yaml.MapSlice) bool {
+	module, ok := mapSliceGet(m, "module")
+	if !ok {
+		return true
+	}
+	baseModule, _ := mapSliceGet(base, "module")
+	return fmt.Sprint(module) == fmt.Sprint(baseModule)
+}
+
+// mergeData merges the data items of a mapping with those of its base, by name
+func mergeData(baseData interface{}, data interface{}) ([]interface{}, error) {
+	baseItems, _ := baseData.([]interface{})
+	items, ok := data.([]interface{})
+	if !ok {
+		return nil, errors.New("data must be a list")
+	}
+	merged := make([]interface{}, len(baseItems))
+	copy(merged, baseItems)
+	for _, item := range items {
+		itemMap, ok := item.(yaml.MapSlice)
+		if !ok {
+			return nil, errors.New("data items must be objects")
+		}
+		name, _ := mapSliceGet(itemMap, "name")
+		i := indexOfDataItem(merged, name)
+		if remove, _ := mapSliceGet(itemMap, removeKey); remove == true {
+			if i < 0 {
+				return nil, fmt.Errorf("unable to remove data item %v: not in the base", name)
+			}
+			merged = append(merged[:i], merged[i+1:]...)
+			continue
+		}
+		itemMap = mapSliceDelete(itemMap, removeKey)
+		if i < 0 {
+			merged = append(merged, itemMap)
+		} else {
+			merged[i] = itemMap
+		}
+	}
+	return merged, nil
+}
+
+// hasRemovedData returns true if any data item of m sets remove
+func hasRemovedData(m yaml.MapSlice) bool {
+	data, _ := mapSliceGet(m, "data")
+	items, _ := data.([]interface{})
+	for _, item := range items {
+		if itemMap, ok := item.(yaml.MapSlice); ok {
+			if _, ok := mapSliceGet(itemMap, removeKey); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// indexOfDataItem returns the index of the data item named name, or -1
+func indexOfDataItem(items []interface{}, name interface{}) int {
+	for i, item := range items {
+		if itemMap, ok := item.(yaml.MapSlice); ok {
+			if n, _ := mapSliceGet(itemMap, "name"); n == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func mapSliceGet(m yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// mapSliceSet replaces the value of key in m, or appends it
+func mapSliceSet(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+func mapSliceDelete(m yaml.MapSlice, key string) yaml.MapSlice {
+	out := yaml.MapSlice{}
+	for _, item := range m {
+		if fmt.Sprint(item.Key) != key {
+			out = append(out, item)
+		}
+	}
+	return out
+}
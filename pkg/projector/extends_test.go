@@ -0,0 +1,184 @@
+package projector
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+)
+
+func TestLoadExtendedProjectionMappings(t *testing.T) {
+	c, err := conf.LoadConfigFromArgs([]string{os.Args[0],
+		"-creds-repo=production=test/fixtures/files",
+		"-manifests=test/fixtures/manifests-extends",
+		"-creds-encryption-key=test/fixtures/files/encryption-cbc-key.json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappings, err := New(c).LoadProjectionMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := map[string]types.ProjectionMapping{}
+	for _, m := range mappings {
+		loaded[m.GetName()] = m
+	}
+	// the _base.yaml template is not projected on its own
+	if len(loaded) != 2 {
+		t.Fatalf("expected 2 projection mappings, got %v", mappings)
+	}
+
+	app := loaded["app"]
+	if app == nil {
+		t.Fatal("expected the app mapping to be loaded")
+	}
+	if app.String() != "json-tests/app:production{single-json-key:json:object1.json,another-json-key:json:object1.json}" {
+		t.Errorf("expected app to inherit single-json-key, drop raw-file and add another-json-key, got %s", app.String())
+	}
+	if e := app.GetEncryptionConfig(); e.Module != "cbc" || e.Params["hash"] != "md5" || e.Params["note"] != "" {
+		t.Errorf("expected app to inherit encryption without the note param, got %+v", e)
+	}
+	fsys, err := creds.NewDir("test/fixtures/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := app.ProjectSecret(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["single-json-key"]) == "paSsw0rd!" {
+		t.Errorf("expected inherited single-json-key to be encrypted")
+	}
+
+	plain := loaded["plain"]
+	if plain == nil {
+		t.Fatal("expected the plain mapping to be loaded")
+	}
+	if plain.GetNamespace() != "raw-test1" || plain.GetEncryptionConfig().Module != "" {
+		t.Errorf("expected plain to override the namespace and remove encryption, got %s %+v", plain.String(), plain.GetEncryptionConfig())
+	}
+	if plain.String() != "raw-test1/plain:production{single-json-key:json:object1.json,raw-file:raw:raw1.txt}" {
+		t.Errorf("expected plain to replace single-json-key in place, got %s", plain.String())
+	}
+}
+
+func TestResolveExtendsErrors(t *testing.T) {
+	doc := func(file string, raw string) mappingDocument {
+		return mappingDocument{source: file, file: file, raw: []byte(raw), documents: 1}
+	}
+	for _, tc := range []struct {
+		docs     []mappingDocument
+		expected string
+	}{
+		{[]mappingDocument{doc("a.yaml", "extends: b.yaml\n"), doc("b.yaml", "extends: a.yaml\n")}, "extends cycle: a.yaml -> b.yaml -> a.yaml"},
+		{[]mappingDocument{doc("a.yaml", "extends: a.yaml\n")}, "extends cycle: a.yaml -> a.yaml"},
+		{[]mappingDocument{doc("a.yaml", "extends: missing.yaml\n")}, "no such projection mapping file missing.yaml"},
+		{[]mappingDocument{doc("a/a.yaml", "extends: ../../b.yaml\n")}, "outside of the projection mappings root"},
+		{[]mappingDocument{doc("a.yaml", "extends: b.yaml\ndata:\n- name: x\n  remove: true\n"), doc("b.yaml", "data: []\n")}, "unable to remove data item x"},
+		{[]mappingDocument{doc("a.yaml", "data:\n- name: x\n  remove: true\n")}, ErrRemoveWithoutExtends.Error()},
+		{[]mappingDocument{doc("a.yaml", "apiVersion: secret-projector.tumblr.com/v2\nextends: b.yaml\n"), doc("b.yaml", "name: b\n")}, "does not match the base apiVersion"},
+		{[]mappingDocument{{source: "stdin", raw: []byte("extends: b.yaml\n")}}, ErrExtendsWithoutFile.Error()},
+	} {
+		_, errs := resolveExtends(tc.docs)
+		err, ok := errs[tc.docs[0].source]
+		if !ok || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("expected an error containing %q resolving %s, got %v", tc.expected, tc.docs[0].raw, err)
+		}
+	}
+}
+
+func TestResolveExtendsChain(t *testing.T) {
+	docs := []mappingDocument{
+		{source: "c.yaml", file: "c.yaml", raw: []byte("extends: _b.yaml\nname: c\n"), documents: 1},
+		{source: "_b.yaml", file: "_b.yaml", raw: []byte("extends: _a.yaml\nnamespace: b\n"), documents: 1},
+		{source: "_a.yaml", file: "_a.yaml", raw: []byte("name: a\nnamespace: a\nrepo: a\n"), documents: 1},
+	}
+	resolved, errs := resolveExtends(docs)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(resolved) != 1 {
+		t.Fatalf("expected only c.yaml to be resolved, got %v", resolved)
+	}
+	if string(resolved[0].raw) != "name: c\nnamespace: b\nrepo: a\n" {
+		t.Errorf("unexpected resolved mapping:\n%s", resolved[0].raw)
+	}
+}
+
+func TestResolveExtendsEncryption(t *testing.T) {
+	base := "name: a\nnamespace: a\nrepo: a\nencryption:\n  module: cbc\n  params:\n    hash: sha256\n    kdf: scrypt\n"
+	for _, tc := range []struct {
+		encryption string
+		expected   string
+	}{
+		// the same module merges params
+		{"encryption:\n  module: cbc\n  params:\n    hash: sha512\n", "encryption:\n  module: cbc\n  params:\n    hash: sha512\n    kdf: scrypt\n"},
+		{"encryption:\n  params:\n    kdf: legacy\n", "encryption:\n  module: cbc\n  params:\n    hash: sha256\n    kdf: legacy\n"},
+		// another module replaces the encryption, leaving none of the cbc params behind
+		{"encryption:\n  module: kms\n  params:\n    endpoint: unix:///kms.sock\n", "encryption:\n  module: kms\n  params:\n    endpoint: unix:///kms.sock\n"},
+		{"encryption:\n  module: rsa-oaep\n", "encryption:\n  module: rsa-oaep\n"},
+	} {
+		docs := []mappingDocument{
+			{source: "b.yaml", file: "b.yaml", raw: []byte("extends: _a.yaml\n" + tc.encryption), documents: 1},
+			{source: "_a.yaml", file: "_a.yaml", raw: []byte(base), documents: 1},
+		}
+		resolved, errs := resolveExtends(docs)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		expected := "name: a\nnamespace: a\nrepo: a\n" + tc.expected
+		if string(resolved[0].raw) != expected {
+			t.Errorf("expected\n%s\nextending with\n%s\ngot\n%s", expected, tc.encryption, resolved[0].raw)
+		}
+	}
+}
+
+func TestResolveExtendsNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		base     string
+		m        string
+		expected string
+	}{
+		{"namespace: a\n", "namespaces: [b, c]\n", "namespaces:\n- b\n- c\n"},
+		{"namespaces: [a, b]\n", "namespace: c\n", "namespace: c\n"},
+	} {
+		docs := []mappingDocument{
+			{source: "b.yaml", file: "b.yaml", raw: []byte("extends: _a.yaml\n" + tc.m), documents: 1},
+			{source: "_a.yaml", file: "_a.yaml", raw: []byte("name: a\n" + tc.base), documents: 1},
+		}
+		resolved, errs := resolveExtends(docs)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if expected := "name: a\n" + tc.expected; string(resolved[0].raw) != expected {
+			t.Errorf("expected\n%s\nextending %s with %s, got\n%s", expected, tc.base, tc.m, resolved[0].raw)
+		}
+	}
+}
+
+func TestResolveExtendsTemplates(t *testing.T) {
+	docs := []mappingDocument{
+		{source: "b.yaml", file: "b.yaml", raw: []byte("extends: _a.yaml\nname: b\n"), documents: 1},
+		{source: "_a.yaml", file: "_a.yaml", raw: []byte("name: a\nnamespace: a\nrepo: a\n"), documents: 1},
+		// not extended by anything, so projected like any other mapping
+		{source: "_c.yaml", file: "_c.yaml", raw: []byte("name: c\nnamespace: c\nrepo: c\n"), documents: 1},
+		// extended, but not named like a template
+		{source: "d.yaml", file: "d.yaml", raw: []byte("name: d\nnamespace: d\nrepo: d\n"), documents: 1},
+		{source: "e.yaml", file: "e.yaml", raw: []byte("extends: d.yaml\nname: e\n"), documents: 1},
+	}
+	resolved, errs := resolveExtends(docs)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	sources := []string{}
+	for _, doc := range resolved {
+		sources = append(sources, doc.source)
+	}
+	if strings.Join(sources, ",") != "b.yaml,_c.yaml,d.yaml,e.yaml" {
+		t.Errorf("expected only the extended _a.yaml template to be dropped, got %v", sources)
+	}
+}
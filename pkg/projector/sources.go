@@ -0,0 +1,185 @@
+package projector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+)
+
+const (
+	// StdinMappingsPath is the --manifests value that reads a stream of projection mappings from stdin
+	StdinMappingsPath = "-"
+)
+
+var (
+	// mappingFileExtensions are the extensions of files loaded as projection mappings
+	mappingFileExtensions = []string{".yaml", ".yml", ".json"}
+	// a line that separates documents in a YAML stream
+	yamlDocumentSeparator = regexp.MustCompile(`^---(\s.*)?$`)
+)
+
+// mappingDocument is the raw yaml of a single projection mapping, along with a
+// description of where it was read from for error reporting
+type mappingDocument struct {
+	source string
+	raw    []byte
+	// file is the slash separated path of the file the document was read from, relative to
+	// the projection mappings root, or empty if it wasnt read from a file (i.e. stdin)
+	file string
+	// documents is the number of documents in file
+	documents int
+}
+
+// readProjectionMappingDocuments reads every projection mapping document from the
+// projection mappings root path, which is either a directory, an archive of one
+// (see creds.IsArchive), or "-" for a multi-document yaml stream on stdin
+func (a *app) readProjectionMappingDocuments() ([]mappingDocument, error) {
+	root := a.ProjectionMappingsRootPath()
+	switch {
+	case root == StdinMappingsPath:
+		return readMappingStream("stdin", a.stdin)
+	case creds.IsArchive(root):
+		return readMappingArchive(root)
+	default:
+		return readMappingDir(root)
+	}
+}
+
+// isMappingFile returns true if the file name has an extension projection mappings are loaded from
+func isMappingFile(name string) bool {
+	for _, ext := range mappingFileExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitMappingFile splits a file into its projection mapping documents. yaml files may
+// contain multiple documents separated by ---, json files only ever hold one. name
+// describes the file, and file is its path relative to the projection mappings root.
+func splitMappingFile(name string, file string, raw []byte) []mappingDocument {
+	if strings.HasSuffix(name, ".json") {
+		return []mappingDocument{{source: name, raw: raw, file: file, documents: 1}}
+	}
+	split := SplitYAMLDocuments(raw)
+	docs := make([]mappingDocument, len(split))
+	for i, doc := range split {
+		source := name
+		if len(split) > 1 {
+			source = fmt.Sprintf("%s (document %d)", name, i+1)
+		}
+		docs[i] = mappingDocument{source: source, raw: doc, file: file, documents: len(split)}
+	}
+	return docs
+}
+
+func readMappingDir(root string) ([]mappingDocument, error) {
+	docs := []mappingDocument{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if info == nil {
+			return nil
+		}
+		// skip hidden directories, like .git
+		if info.IsDir() {
+			if path != root && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isMappingFile(info.Name()) {
+			log.Printf("Skipping %s in projection mappings: not a %s file\n", path, strings.Join(mappingFileExtensions, ", "))
+			return nil
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read projection mapping %s: %s", path, err.Error())
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, splitMappingFile(path, filepath.ToSlash(rel), raw)...)
+		return nil
+	})
+	return docs, err
+}
+
+// readMappingArchive reads projection mappings out of a tar (optionally gzipped) or zip archive
+func readMappingArchive(archive string) ([]mappingDocument, error) {
+	files, err := creds.OpenArchive(archive)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read projection mappings from %s: %s", archive, err.Error())
+	}
+	names := []string{}
+	for name := range files {
+		if isMappingFile(name) {
+			names = append(names, name)
+		} else {
+			log.Printf("Skipping %s:%s in projection mappings: not a %s file\n", archive, name, strings.Join(mappingFileExtensions, ", "))
+		}
+	}
+	sort.Strings(names)
+	docs := []mappingDocument{}
+	for _, name := range names {
+		docs = append(docs, splitMappingFile(fmt.Sprintf("%s:%s", archive, name), name, files[name])...)
+	}
+	return docs, nil
+}
+
+// readMappingStream reads a multi-document yaml stream of projection mappings
+func readMappingStream(name string, r io.Reader) ([]mappingDocument, error) {
+	if r == nil {
+		return nil, fmt.Errorf("no %s to read projection mappings from", name)
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read projection mappings from %s: %s", name, err.Error())
+	}
+	docs := []mappingDocument{}
+	for i, doc := range SplitYAMLDocuments(raw) {
+		docs = append(docs, mappingDocument{source: fmt.Sprintf("%s (document %d)", name, i+1), raw: doc})
+	}
+	return docs, nil
+}
+
+// SplitYAMLDocuments splits a yaml stream on --- separators, dropping any documents
+// that are empty or only contain comments
+func SplitYAMLDocuments(raw []byte) [][]byte {
+	docs := [][]byte{}
+	current := bytes.NewBuffer(nil)
+	empty := true
+	flush := func() {
+		if !empty {
+			docs = append(docs, current.Bytes())
+		}
+		current = bytes.NewBuffer(nil)
+		empty = true
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), len(raw)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if yamlDocumentSeparator.MatchString(line) {
+			flush()
+			continue
+		}
+		if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "#") {
+			empty = false
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+	return docs
+}
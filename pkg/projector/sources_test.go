@@ -0,0 +1,122 @@
+package projector
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing" // hack to make test fixtures non-relative
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+)
+
+var (
+	testMappingFixtures = []string{
+		"test/fixtures/manifests/manifest_1.yaml",
+		"test/fixtures/manifests/raw.yaml",
+	}
+)
+
+func newTestApp(t *testing.T, manifests string) *app {
+	c, err := conf.LoadConfigFromArgs([]string{os.Args[0], "-creds-repo=production=test/fixtures/files", "-manifests=" + manifests})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(c).(*app)
+}
+
+func TestSplitYAMLDocuments(t *testing.T) {
+	stream := `# leading comment
+---
+name: one
+---   # trailing comment
+name: two
+--- 
+# only a comment
+---
+name: three
+...
+`
+	docs := SplitYAMLDocuments([]byte(stream))
+	if len(docs) != 3 {
+		t.Fatalf("expected 3 documents, but got %d: %q", len(docs), docs)
+	}
+	if strings.TrimSpace(string(docs[1])) != "name: two" {
+		t.Errorf("expected second document to be 'name: two', but got %q", docs[1])
+	}
+}
+
+func TestLoadProjectionMappingsFromStdin(t *testing.T) {
+	stream := []string{}
+	for _, f := range testMappingFixtures {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, string(raw))
+	}
+	a := newTestApp(t, StdinMappingsPath)
+	a.stdin = strings.NewReader("---\n" + strings.Join(stream, "\n---\n"))
+	mappings, err := a.LoadProjectionMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 2 || mappings[0].GetName() != "test1" || mappings[1].GetName() != "test2" {
+		t.Fatalf("expected to load test1 and test2 from stdin, but got %v", mappings)
+	}
+}
+
+func TestLoadProjectionMappingsFromTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tarball := filepath.Join(dir, "manifests.tar.gz")
+	f, err := os.Create(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, fixture := range append(testMappingFixtures, "README.md") {
+		raw, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: "manifests/" + filepath.Base(fixture), Mode: 0600, Size: int64(len(raw)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	mappings, err := newTestApp(t, tarball).LoadProjectionMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 2 || mappings[0].GetName() != "test1" || mappings[1].GetName() != "test2" {
+		t.Fatalf("expected to load test1 and test2 from %s, but got %v", tarball, mappings)
+	}
+}
+
+func TestLoadProjectionMappingsMultipleDocumentsAndExtensions(t *testing.T) {
+	mappings, err := newTestApp(t, "test/fixtures/manifests-multi").LoadProjectionMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, m := range mappings {
+		names = append(names, m.GetName())
+	}
+	if strings.Join(names, ",") != "test1,test2,test3" {
+		t.Fatalf("expected to load test1, test2 and test3, but got %v", names)
+	}
+}
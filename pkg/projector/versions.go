@@ -0,0 +1,66 @@
+package projector
+
+import (
+	"fmt"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/schema"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// mappingLoaders load a projection mapping document, keyed by its apiVersion.
+// Documents without an apiVersion predate versioning, and are v1.
+var mappingLoaders = map[string]func([]byte, conf.Config) (types.ProjectionMapping, error){
+	"":                 v1.LoadFromYamlBytes,
+	types.APIVersionV1: v1.LoadFromYamlBytes,
+	types.APIVersionV2: v2.LoadFromYamlBytes,
+}
+
+// MappingAPIVersion returns the apiVersion declared by a projection mapping document,
+// or "" if it doesnt declare one
+func MappingAPIVersion(raw []byte) (string, error) {
+	var header struct {
+		APIVersion string `yaml:"apiVersion"`
+	}
+	if err := yaml.Unmarshal(raw, &header); err != nil {
+		return "", err
+	}
+	return header.APIVersion, nil
+}
+
+// LoadProjectionMapping validates a projection mapping document against the schema of its
+// apiVersion, and loads it with the types package matching its apiVersion
+func LoadProjectionMapping(raw []byte, cfg conf.Config) (types.ProjectionMapping, error) {
+	apiVersion, err := MappingAPIVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+	load, ok := mappingLoaders[apiVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported projection mapping apiVersion %s", apiVersion)
+	}
+	if err := ValidateProjectionMapping(apiVersion, raw); err != nil {
+		return nil, err
+	}
+	return load(raw, cfg)
+}
+
+// ValidateProjectionMapping checks a projection mapping document against the schema of
+// apiVersion. The returned schema.ValidationErrors describe where the document is invalid.
+func ValidateProjectionMapping(apiVersion string, raw []byte) error {
+	s, err := schema.ProjectionMapping(apiVersion)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	if errs := schema.Validate(s, doc); errs != nil {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package projector
+
+import (
+	"testing"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v2"
+)
+
+func TestLoadProjectionMappingDispatchesOnAPIVersion(t *testing.T) {
+	c := newTestApp(t, "test/fixtures/manifests").Config
+	data := "name: x\nnamespace: y\nrepo: production\ndata:\n- name: a\n  source:\n    raw: raw1.txt\n"
+	for raw, check := range map[string]func(interface{}) bool{
+		data: func(m interface{}) bool { _, ok := m.(*v1.ProjectionMapping); return ok },
+		"apiVersion: secret-projector.tumblr.com/v1\nkind: ProjectionMapping\n" + data: func(m interface{}) bool { _, ok := m.(*v1.ProjectionMapping); return ok },
+		"apiVersion: secret-projector.tumblr.com/v2\nkind: ProjectionMapping\n" + data: func(m interface{}) bool { _, ok := m.(*v2.ProjectionMapping); return ok },
+	} {
+		m, err := LoadProjectionMapping([]byte(raw), c)
+		if err != nil {
+			t.Fatalf("unexpected error loading %q: %s", raw, err.Error())
+		}
+		if !check(m) {
+			t.Errorf("loaded %q as the wrong type %T", raw, m)
+		}
+	}
+	if _, err := LoadProjectionMapping([]byte("apiVersion: secret-projector.tumblr.com/v9\n"+data), c); err == nil {
+		t.Errorf("expected an error for an unsupported apiVersion")
+	}
+}
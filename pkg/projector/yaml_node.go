@@ -0,0 +1,150 @@
+package projector
+
+import (
+	"errors"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/schema"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// errMappingNotAnObject is returned when a projection mapping document is not a yaml map
+	errMappingNotAnObject = errors.New("projection mapping must be an object")
+)
+
+// yamlNode is a yaml value that remembers the original text of scalars. yaml.v2 resolves
+// scalars with yaml 1.1 rules (i.e. y is true), but decodes any scalar into a string field
+// using its original text, so a document that is decoded and re-marshalled would change
+// unless string fields are written out with their original text.
+type yamlNode struct {
+	m      yaml.MapSlice
+	values map[string]yamlNode
+	list   []yamlNode
+	scalar interface{}
+	text   string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (n *yamlNode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	switch v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		n.list = []yamlNode{}
+		return unmarshal(&n.list)
+	case map[interface{}]interface{}:
+		if err := unmarshal(&n.values); err != nil {
+			return err
+		}
+		// the MapSlice keeps the order of the keys
+		if err := unmarshal(&n.m); err != nil {
+			return err
+		}
+		if n.m == nil {
+			n.m = yaml.MapSlice{}
+		}
+		return nil
+	}
+	n.scalar = v
+	return unmarshal(&n.text)
+}
+
+// normalize returns the value of n, as yaml.MapSlice, []interface{} and scalars, with
+// scalars that s says are strings replaced by their original text
+func (n yamlNode) normalize(s *schema.Schema) interface{} {
+	switch {
+	case n.m != nil:
+		out := yaml.MapSlice{}
+		for _, item := range n.m {
+			key, _ := item.Key.(string)
+			out = append(out, yaml.MapItem{Key: item.Key, Value: n.values[key].normalize(propertySchema(s, key))})
+		}
+		return out
+	case n.list != nil:
+		out := make([]interface{}, len(n.list))
+		for i, item := range n.list {
+			var items *schema.Schema
+			if s != nil {
+				items = s.Items
+			}
+			out[i] = item.normalize(items)
+		}
+		return out
+	case n.scalar != nil && s != nil && s.Type == "string":
+		return n.text
+	}
+	return n.scalar
+}
+
+// propertySchema returns the schema of property key of s, or nil if it is unknown
+func propertySchema(s *schema.Schema, key string) *schema.Schema {
+	if s == nil {
+		return nil
+	}
+	if p, ok := s.Properties[key]; ok {
+		return p
+	}
+	if p, ok := s.AdditionalProperties.(*schema.Schema); ok {
+		return p
+	}
+	return nil
+}
+
+// parseMapping decodes a projection mapping document for merging, keeping the original
+// text of string fields (see yamlNode). Documents are normalized with the schema of their
+// apiVersion, or of apiVersion if it is set.
+func parseMapping(raw []byte, apiVersion string) (yaml.MapSlice, error) {
+	var n yamlNode
+	if err := yaml.Unmarshal(raw, &n); err != nil {
+		return nil, err
+	}
+	if n.m == nil && n.list == nil && n.scalar == nil {
+		// an empty document
+		return yaml.MapSlice{}, nil
+	}
+	if n.m == nil {
+		return nil, errMappingNotAnObject
+	}
+	if apiVersion == "" {
+		apiVersion = types.APIVersionV1
+		if v, ok := n.values["apiVersion"]; ok && v.text != "" {
+			apiVersion = v.text
+		}
+	}
+	s, err := schema.ProjectionMappingFile(apiVersion)
+	if err != nil {
+		// unsupported apiVersions are reported when the document is loaded
+		s = nil
+	}
+	return n.normalize(s).(yaml.MapSlice), nil
+}
+
+// ParseMappingFile decodes a projection mapping document as written in a file, before extends
+// is resolved, checking it against the schema of mapping files of its apiVersion (see
+// schema.ProjectionMappingFile). String fields keep their original text (see yamlNode).
+func ParseMappingFile(raw []byte) (yaml.MapSlice, error) {
+	apiVersion, err := MappingAPIVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+	if apiVersion == "" {
+		apiVersion = types.APIVersionV1
+	}
+	s, err := schema.ProjectionMappingFile(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if errs := schema.Validate(s, doc); errs != nil {
+		return nil, errs
+	}
+	return parseMapping(raw, apiVersion)
+}
@@ -0,0 +1,108 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+)
+
+// Consumer is a single data item in a Secret that is projected from a creds source
+type Consumer struct {
+	Namespace string `json:"namespace"`
+	Secret    string `json:"secret"`
+	Key       string `json:"key"`
+}
+
+// Entry lists every consumer of a single field (or entire file, if JSONPath is empty)
+// in a creds repo
+type Entry struct {
+	Repo      string     `json:"repo"`
+	Path      string     `json:"path"`
+	JSONPath  string     `json:"jsonpath,omitempty"`
+	Consumers []Consumer `json:"consumers"`
+}
+
+// AccessReport is a reverse index of creds sources to the Secrets that consume them.
+// It is built only from the projection mappings, so it never reads any secret values.
+type AccessReport []Entry
+
+type entryKey struct {
+	repo     string
+	path     string
+	jsonPath string
+}
+
+// NewAccessReport builds an AccessReport from a set of projection mappings. Entries and
+// their consumers are sorted, so reports are stable between runs.
+func NewAccessReport(mappings []types.ProjectionMapping) AccessReport {
+	index := map[entryKey][]Consumer{}
+	for _, m := range mappings {
+		for _, ref := range m.GetSourceReferences() {
+			jsonPaths := ref.JSONPaths
+			if len(jsonPaths) == 0 {
+				jsonPaths = []string{""}
+			}
+			// a mapping fanned out to many namespaces has a consumer in each
+			for _, ns := range m.GetNamespaces() {
+				c := Consumer{Namespace: ns, Secret: m.GetName(), Key: ref.Key}
+				for _, p := range jsonPaths {
+					k := entryKey{repo: ref.Repo, path: ref.Path, jsonPath: p}
+					index[k] = append(index[k], c)
+				}
+			}
+		}
+	}
+
+	r := AccessReport{}
+	for k, consumers := range index {
+		sort.Slice(consumers, func(i, j int) bool {
+			if consumers[i].Namespace != consumers[j].Namespace {
+				return consumers[i].Namespace < consumers[j].Namespace
+			}
+			if consumers[i].Secret != consumers[j].Secret {
+				return consumers[i].Secret < consumers[j].Secret
+			}
+			return consumers[i].Key < consumers[j].Key
+		})
+		r = append(r, Entry{Repo: k.repo, Path: k.path, JSONPath: k.jsonPath, Consumers: consumers})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Repo != r[j].Repo {
+			return r[i].Repo < r[j].Repo
+		}
+		if r[i].Path != r[j].Path {
+			return r[i].Path < r[j].Path
+		}
+		return r[i].JSONPath < r[j].JSONPath
+	})
+	return r
+}
+
+// WriteJSON writes the report as a JSON list of entries
+func (r AccessReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the report as CSV, one row per consumer of each entry
+func (r AccessReport) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	err := out.Write([]string{"repo", "path", "jsonpath", "namespace", "secret", "key"})
+	if err != nil {
+		return err
+	}
+	for _, e := range r {
+		for _, c := range e.Consumers {
+			err = out.Write([]string{e.Repo, e.Path, e.JSONPath, c.Namespace, c.Secret, c.Key})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
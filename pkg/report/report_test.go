@@ -0,0 +1,77 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+)
+
+var (
+	testMappings = []types.ProjectionMapping{
+		&v1.ProjectionMapping{
+			Name:      "test1",
+			Namespace: "json-tests",
+			Repo:      "production",
+			Data: []v1.Secret{
+				{Name: "single-json-key", Source: v1.DataSource{JSON: "object1.json", JSONPath: "$.secret"}},
+				{Name: "secrets.json", Source: v1.DataSource{JSON: "object1.json", JSONPaths: map[string]types.JSONPathSelector{
+					"key1":   "$.nesting.key1",
+					"secret": "$.secret",
+				}}},
+			},
+		},
+		&v1.ProjectionMapping{
+			Name:      "test2",
+			Namespace: "raw-test1",
+			Repo:      "production",
+			Data: []v1.Secret{
+				{Name: "raw-file", Source: v1.DataSource{Raw: "raw1.txt"}},
+			},
+		},
+		&v1.ProjectionMapping{
+			Name:       "test3",
+			Namespaces: []string{"web-1", "web-2"},
+			Repo:       "production",
+			Data: []v1.Secret{
+				{Name: "raw-file", Source: v1.DataSource{Raw: "raw1.txt"}},
+			},
+		},
+	}
+
+	expectedCSV = `repo,path,jsonpath,namespace,secret,key
+production,object1.json,$.nesting.key1,json-tests,test1,secrets.json
+production,object1.json,$.secret,json-tests,test1,secrets.json
+production,object1.json,$.secret,json-tests,test1,single-json-key
+production,raw1.txt,,raw-test1,test2,raw-file
+production,raw1.txt,,web-1,test3,raw-file
+production,raw1.txt,,web-2,test3,raw-file
+`
+)
+
+func TestAccessReport(t *testing.T) {
+	r := NewAccessReport(testMappings)
+	if len(r) != 3 {
+		t.Fatalf("expected 3 entries in the report, but got %d: %v", len(r), r)
+	}
+	if len(r[1].Consumers) != 2 {
+		t.Errorf("expected $.secret to have 2 consumers, but got %v", r[1].Consumers)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := r.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expectedCSV {
+		t.Errorf("expected CSV report:\n%s\nBut got:\n%s", expectedCSV, buf.String())
+	}
+
+	buf.Reset()
+	if err := r.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"jsonpath": "$.nesting.key1"`)) {
+		t.Errorf("expected JSON report to include $.nesting.key1, but got:\n%s", buf.String())
+	}
+}
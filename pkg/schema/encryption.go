@@ -0,0 +1,78 @@
+package schema
+
+import (
+	"sort"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
+)
+
+// encryptionModules returns a schema for each registered encryption module, matching the
+// encryption configs that select it with `module`, and describing the params it accepts
+func encryptionModules() []*Schema {
+	names := encryption.List()
+	modules := make([]*Schema, 0, len(names))
+	for _, name := range names {
+		r, _ := encryption.Lookup(name)
+		modules = append(modules, Encryption(r))
+	}
+	return modules
+}
+
+// Encryption returns the schema of the encryption configs selecting the module of r. Its
+// params are closed to those r declares, unless r declares none at all (i.e. the plugin
+// module), and then any params are accepted.
+func Encryption(r encryption.Registration) *Schema {
+	s := &Schema{
+		Type:       "object",
+		Properties: map[string]*Schema{"module": {Type: "string", Enum: []interface{}{r.Name}}},
+	}
+	if r.Params == nil {
+		return s
+	}
+	params := &Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: false,
+	}
+	for name, p := range r.Params {
+		ps := &Schema{Type: "string", Description: p.Description, Pattern: p.Pattern}
+		if p.Default != "" {
+			ps.Default = p.Default
+		}
+		for _, v := range p.Values {
+			ps.Enum = append(ps.Enum, v)
+		}
+		if p.Required {
+			params.Required = append(params.Required, name)
+		}
+		params.Properties[name] = ps
+	}
+	if len(params.Required) == 0 {
+		params.Nullable = true
+	} else {
+		sort.Strings(params.Required)
+	}
+	s.Properties["params"] = params
+	return s
+}
+
+// partialEncryption relaxes the encryption configs in s, as a mapping file may inherit the
+// module and params of its encryption from the mapping it extends, or from defaults
+func partialEncryption(s *Schema) {
+	if s == nil {
+		return
+	}
+	if s.encryption {
+		s.Required = nil
+		for _, module := range s.OneOf {
+			if params := module.Properties["params"]; params != nil {
+				params.Required = nil
+				params.Nullable = true
+			}
+		}
+	}
+	for _, p := range s.Properties {
+		partialEncryption(p)
+	}
+	partialEncryption(s.Items)
+}
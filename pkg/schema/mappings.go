@@ -0,0 +1,61 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v2"
+)
+
+// mappingTypes are the Go types of projection mappings, keyed by apiVersion
+var mappingTypes = map[string]reflect.Type{
+	types.APIVersionV1: reflect.TypeOf(v1.ProjectionMapping{}),
+	types.APIVersionV2: reflect.TypeOf(v2.ProjectionMapping{}),
+}
+
+// ProjectionMapping returns the schema of projection mappings of an apiVersion.
+// An empty apiVersion is v1, which predates versioning.
+func ProjectionMapping(apiVersion string) (*Schema, error) {
+	if apiVersion == "" {
+		apiVersion = types.APIVersionV1
+	}
+	t, ok := mappingTypes[apiVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported projection mapping apiVersion %s", apiVersion)
+	}
+	s := For(t)
+	s.Schema = Draft
+	s.Title = fmt.Sprintf("%s %s", apiVersion, types.ProjectionMappingKind)
+	s.Properties["apiVersion"].Enum = []interface{}{apiVersion}
+	s.Properties["kind"].Enum = []interface{}{types.ProjectionMappingKind}
+	// a mapping is projected into a single namespace, or fans out to namespaces
+	s.OneOf = []*Schema{{Required: []string{"namespace"}}, {Required: []string{"namespaces"}}}
+	return s, nil
+}
+
+// ProjectionMappingFile returns the schema of projection mappings of an apiVersion as
+// written in files, before the projector resolves extends. On top of ProjectionMapping,
+// a mapping may extend a base file, and remove data items inherited from it.
+func ProjectionMappingFile(apiVersion string) (*Schema, error) {
+	s, err := ProjectionMapping(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	s.Properties["extends"] = &Schema{Type: "string"}
+	s.Properties["data"].Items.Properties["remove"] = &Schema{Type: "boolean"}
+	// a mapping that extends another inherits the required fields, except its apiVersion and kind
+	required := []string{}
+	for _, name := range s.Required {
+		if name == "apiVersion" || name == "kind" {
+			required = append(required, name)
+		}
+	}
+	s.Required = required
+	// and may inherit its namespace or namespaces too, and its encryption module and params
+	s.OneOf = nil
+	partialEncryption(s)
+	s.Properties["data"].Items.Required = []string{"name"}
+	return s, nil
+}
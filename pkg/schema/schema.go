@@ -0,0 +1,140 @@
+// Package schema generates JSON Schemas for projection mappings from their Go types,
+// and validates projection mapping documents against them
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+)
+
+const (
+	// Draft is the JSON Schema draft the generated schemas conform to
+	Draft = "http://json-schema.org/draft-07/schema#"
+)
+
+// Schema is the subset of JSON Schema needed to describe projection mappings
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties is false, or the *Schema of every additional property
+	AdditionalProperties interface{}   `json:"additionalProperties,omitempty"`
+	Items                *Schema       `json:"items,omitempty"`
+	Enum                 []interface{} `json:"enum,omitempty"`
+	Default              interface{}   `json:"default,omitempty"`
+	// Pattern is a regular expression string values must match
+	Pattern string `json:"pattern,omitempty"`
+	// OneOf are schemas of which the value must match exactly one, on top of the rest of s
+	OneOf []*Schema `json:"oneOf,omitempty"`
+	// Nullable permits null as well as Type, as yaml decodes an optional field set to
+	// null (i.e. encryption: ~) like an omitted one
+	Nullable bool `json:"-"`
+	// encryption marks the schema of a conf.Encryption
+	encryption bool
+}
+
+// MarshalJSON writes the type of a Nullable schema as [type, "null"]
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	out := struct {
+		*schema
+		Type interface{}   `json:"type,omitempty"`
+		Enum []interface{} `json:"enum,omitempty"`
+	}{schema: (*schema)(s), Enum: s.Enum}
+	if s.Type != "" {
+		out.Type = s.Type
+	}
+	if s.Nullable && s.Type != "" {
+		out.Type = []string{s.Type, "null"}
+		if len(s.Enum) > 0 {
+			out.Enum = append(append([]interface{}{}, s.Enum...), nil)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// enums are the permitted values of string types with a fixed set of values
+var enums = map[reflect.Type][]interface{}{
+	reflect.TypeOf(types.FormatDefault): {string(types.FormatRaw), string(types.FormatJSON), string(types.FormatYAML)},
+}
+
+// encryptionType is the type of encryption configs, whose params depend on their module
+var encryptionType = reflect.TypeOf(conf.Encryption{})
+
+// For returns the schema of values of type t, as decoded by gopkg.in/yaml.v2. Struct fields
+// are named by their yaml tags, and are required unless tagged omitempty. Unknown fields
+// are not allowed, matching yaml.UnmarshalStrict. Fields tagged omitempty may also be null.
+// Encryption configs must match one of the registered encryption modules (see Encryption).
+func For(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return For(t.Elem())
+	case reflect.Struct:
+		s := &Schema{
+			Type:                 "object",
+			Properties:           map[string]*Schema{},
+			AdditionalProperties: false,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				// unexported
+				continue
+			}
+			name, omitempty := yamlFieldName(f)
+			if name == "-" {
+				continue
+			}
+			p := For(f.Type)
+			if omitempty {
+				p.Nullable = true
+			} else {
+				s.Required = append(s.Required, name)
+			}
+			s.Properties[name] = p
+		}
+		if t == encryptionType {
+			s.encryption = true
+			s.OneOf = encryptionModules()
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: For(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: For(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: "string", Enum: enums[t]}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	// anything goes
+	return &Schema{}
+}
+
+// yamlFieldName returns the name yaml.v2 uses for a struct field, and whether it is omitempty
+func yamlFieldName(f reflect.StructField) (string, bool) {
+	tag := strings.Split(f.Tag.Get("yaml"), ",")
+	name := tag[0]
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	omitempty := false
+	for _, opt := range tag[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
@@ -0,0 +1,262 @@
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing" // hack to make test fixtures non-relative
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+func mustValidate(t *testing.T, apiVersion, raw string) ValidationErrors {
+	s, err := ProjectionMapping(apiVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal([]byte(raw), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return Validate(s, doc)
+}
+
+func TestForStruct(t *testing.T) {
+	type example struct {
+		Name     string            `yaml:"name"`
+		Optional bool              `yaml:"optional,omitempty"`
+		Counts   []int             `yaml:"counts,omitempty"`
+		Tags     map[string]string `yaml:"tags,omitempty"`
+		Skipped  string            `yaml:"-"`
+		Untagged string
+		internal string
+	}
+	s := For(reflect.TypeOf(example{}))
+	if s.Type != "object" || s.AdditionalProperties != false {
+		t.Fatalf("expected a closed object, got %+v", s)
+	}
+	expected := map[string]string{"name": "string", "optional": "boolean", "counts": "array", "tags": "object", "untagged": "string"}
+	if len(s.Properties) != len(expected) {
+		t.Errorf("expected properties %v, got %v", expected, s.Properties)
+	}
+	for name, typ := range expected {
+		if p, ok := s.Properties[name]; !ok || p.Type != typ {
+			t.Errorf("expected property %s of type %s, got %+v", name, typ, p)
+		}
+	}
+	if !reflect.DeepEqual(s.Required, []string{"name", "untagged"}) {
+		t.Errorf("expected name and untagged to be required, got %v", s.Required)
+	}
+	if s.Properties["counts"].Items.Type != "integer" {
+		t.Errorf("expected integer items, got %+v", s.Properties["counts"].Items)
+	}
+	if s.Properties["tags"].AdditionalProperties.(*Schema).Type != "string" {
+		t.Errorf("expected string values, got %+v", s.Properties["tags"].AdditionalProperties)
+	}
+}
+
+func TestFixturesAreValid(t *testing.T) {
+	files, err := filepath.Glob("test/fixtures/manifests/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures found")
+	}
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := mustValidate(t, "", string(raw)); errs != nil {
+			t.Errorf("expected %s to be valid, got %s", f, errs.Error())
+		}
+	}
+}
+
+func TestValidationErrorPaths(t *testing.T) {
+	raw := `name: x
+namespace: 3
+repo: [production]
+extra: true
+data:
+- name: a
+  source:
+    raw: raw1.txt
+- name: b
+  encrypt: "yes"
+  source:
+    jsn: object1.json
+    format: xml
+`
+	expected := ValidationErrors{
+		{"data[1].encrypt", "expected boolean, got string"},
+		{"data[1].source.format", "must be one of raw, json, yaml, got xml"},
+		{"data[1].source", `unknown field "jsn"`},
+		{"", `unknown field "extra"`},
+		{"repo", "expected string, got array"},
+	}
+	errs := mustValidate(t, types.APIVersionV1, raw)
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v, got %v", expected, errs)
+	}
+}
+
+func TestValidationRequiredFields(t *testing.T) {
+	errs := mustValidate(t, types.APIVersionV2, "name: x\nnamespace: y\nrepo: z\ndata: []\n")
+	expected := ValidationErrors{
+		{"", `missing required field "apiVersion"`},
+		{"", `missing required field "kind"`},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v, got %v", expected, errs)
+	}
+	errs = mustValidate(t, types.APIVersionV1, "apiVersion: secret-projector.tumblr.com/v2\nname: x\nnamespace: y\nrepo: z\ndata: []\n")
+	if len(errs) != 1 || errs[0].Path != "apiVersion" {
+		t.Errorf("expected a single apiVersion error, got %v", errs)
+	}
+}
+
+func TestValidationNamespaceOrNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		namespaces string
+		expected   ValidationErrors
+	}{
+		{"namespace: y\n", nil},
+		{"namespaces: [y, z]\n", nil},
+		{"", ValidationErrors{{"", `missing required field "namespace", or missing required field "namespaces"`}}},
+		{"namespace: y\nnamespaces: [z]\n", ValidationErrors{{"", "must match exactly one of its 2 schemas, but matched 2"}}},
+	} {
+		errs := mustValidate(t, types.APIVersionV1, "name: x\n"+tc.namespaces+"repo: z\ndata: []\n")
+		if !reflect.DeepEqual(errs, tc.expected) {
+			t.Errorf("expected %v for %q, got %v", tc.expected, tc.namespaces, errs)
+		}
+	}
+	// mapping files may inherit their namespace from the file they extend
+	s, err := ProjectionMappingFile(types.APIVersionV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal([]byte("extends: base.yaml\nname: x\n"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(s, doc); errs != nil {
+		t.Errorf("expected a mapping file without a namespace to be valid, got %v", errs)
+	}
+}
+
+func TestValidationEncryption(t *testing.T) {
+	for _, tc := range []struct {
+		encryption string
+		expected   ValidationErrors
+	}{
+		{"module: cbc\n  params:\n    hash: sha256\n    scrypt-n: 1024\n", nil},
+		{"module: kms\n  params:\n    endpoint: unix:///kms.sock\n    timeout: 1m30s\n", nil},
+		{"module: plugin\n  plugin-path: bin/plugins/cbc\n  params:\n    anything: goes\n", nil},
+		{"module: rsa-oaep\n", nil},
+		{"module: rot26\n", ValidationErrors{{"encryption.module", "must be one of cbc, dek, kms, plugin, rsa-oaep, got rot26"}}},
+		{"module: cbc\n  params:\n    hash: crc32\n", ValidationErrors{{"encryption.params.hash", "must be one of md5, sha1, sha256, sha512, got crc32"}}},
+		{"module: cbc\n  params:\n    scrypt-n: 0\n", ValidationErrors{{"encryption.params.scrypt-n", "must match ^[1-9][0-9]*$, got 0"}}},
+		{"module: cbc\n  params:\n    scope: key\n", ValidationErrors{{"encryption.params", `unknown field "scope"`}}},
+		{"module: kms\n  params:\n    timeout: soon\n", ValidationErrors{
+			{"encryption.params", `missing required field "endpoint"`},
+			{"encryption.params.timeout", "must match ^[-+]?(0|([0-9]*(\\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$, got soon"},
+		}},
+		{"params:\n    hash: sha1\n", ValidationErrors{{"encryption", `missing required field "module"`}}},
+	} {
+		errs := mustValidate(t, types.APIVersionV1, "name: x\nnamespace: y\nrepo: z\ndata: []\nencryption:\n  "+tc.encryption)
+		if !reflect.DeepEqual(errs, tc.expected) {
+			t.Errorf("expected %v for %q, got %v", tc.expected, tc.encryption, errs)
+		}
+	}
+
+	// mapping files may inherit the module and required params of their encryption
+	s, err := ProjectionMappingFile(types.APIVersionV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{"encryption:\n  params:\n    timeout: 5s\n", "encryption:\n  module: kms\n"} {
+		var doc interface{}
+		if err := yaml.Unmarshal([]byte("apiVersion: "+types.APIVersionV2+"\nkind: ProjectionMapping\nextends: base.yaml\n"+raw), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if errs := Validate(s, doc); errs != nil {
+			t.Errorf("expected %q to be valid in a mapping file, got %v", raw, errs)
+		}
+	}
+}
+
+func TestEncryptionAgreesWithValidateParams(t *testing.T) {
+	for _, tc := range []struct {
+		module string
+		params map[string]string
+	}{
+		{"cbc", map[string]string{"hash": "sha256", "scrypt-n": "1024"}},
+		{"cbc", map[string]string{"hash": "crc32"}},
+		{"cbc", map[string]string{"scrypt-n": "0"}},
+		{"cbc", map[string]string{"scope": "key"}},
+		{"dek", map[string]string{"scope": "key"}},
+		{"kms", map[string]string{}},
+		{"kms", map[string]string{"endpoint": "unix:///kms.sock", "timeout": "1m30s"}},
+		{"kms", map[string]string{"endpoint": "unix:///kms.sock", "timeout": "soon"}},
+	} {
+		r, ok := encryption.Lookup(tc.module)
+		if !ok {
+			t.Fatalf("expected module %s to be registered", tc.module)
+		}
+		// documents are decoded from yaml
+		params, docParams := map[string]string{}, map[interface{}]interface{}{}
+		for name, v := range tc.params {
+			params[name], docParams[name] = v, v
+		}
+		schemaErrs := Validate(Encryption(r), map[interface{}]interface{}{"module": tc.module, "params": docParams})
+		paramsErr := r.ValidateParams(params)
+		if (schemaErrs == nil) != (paramsErr == nil) {
+			t.Errorf("expected the schema and ValidateParams to agree on %s %v, got %v and %v", tc.module, tc.params, schemaErrs, paramsErr)
+		}
+	}
+}
+
+func TestNullOptionalFields(t *testing.T) {
+	for _, apiVersion := range []string{types.APIVersionV1, types.APIVersionV2} {
+		raw := "apiVersion: " + apiVersion + "\nkind: ProjectionMapping\nname: x\nnamespace: y\nrepo: z\nencryption: ~\nlabels: ~\ndata:\n- name: a\n  encrypt: null\n  source:\n    raw: raw1.txt\n"
+		if errs := mustValidate(t, apiVersion, raw); errs != nil {
+			t.Errorf("[%s] expected optional fields to accept null, got %v", apiVersion, errs)
+		}
+	}
+	errs := mustValidate(t, types.APIVersionV1, "name: ~\nnamespace: y\nrepo: z\ndata: []\n")
+	if len(errs) != 1 || errs[0].Path != "name" {
+		t.Errorf("expected required fields to reject null, got %v", errs)
+	}
+
+	s, err := ProjectionMapping(types.APIVersionV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := json.Marshal(s.Properties["encryption"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(js), `"type":["object","null"]}`) {
+		t.Errorf("expected a nullable object, got %s", js)
+	}
+	js, err = json.Marshal(s.Properties["apiVersion"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != `{"type":["string","null"],"enum":["`+types.APIVersionV1+`",null]}` {
+		t.Errorf("expected a nullable enum, got %s", js)
+	}
+}
+
+func TestUnsupportedAPIVersion(t *testing.T) {
+	if _, err := ProjectionMapping("example.com/v9"); err == nil {
+		t.Errorf("expected an error for an unsupported apiVersion")
+	}
+}
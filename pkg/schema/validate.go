@@ -0,0 +1,244 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidationError describes where in a document it does not match its schema
+type ValidationError struct {
+	// Path is the location of the offending value, like data[0].source, or empty for the document itself
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors are all the ways a document does not match its schema
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks a document decoded by gopkg.in/yaml.v2 against s, returning nil if it matches
+func Validate(s *Schema, doc interface{}) ValidationErrors {
+	errs := validate(s, doc, "")
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validate(s *Schema, v interface{}, path string) ValidationErrors {
+	if v == nil && s.Nullable {
+		return nil
+	}
+	if s.Type != "" && !hasType(v, s.Type) {
+		return ValidationErrors{{path, fmt.Sprintf("expected %s, got %s", s.Type, typeOf(v))}}
+	}
+	if len(s.Enum) > 0 && !inEnum(fmt.Sprint(v), s.Enum) {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			allowed[i] = fmt.Sprint(e)
+		}
+		return ValidationErrors{{path, fmt.Sprintf("must be one of %s, got %v", strings.Join(allowed, ", "), v)}}
+	}
+	if s.Pattern != "" && typeOf(v) != "object" && typeOf(v) != "array" {
+		if ok, err := regexp.MatchString(s.Pattern, fmt.Sprint(v)); err != nil || !ok {
+			return ValidationErrors{{path, fmt.Sprintf("must match %s, got %v", s.Pattern, v)}}
+		}
+	}
+	errs := validateOneOf(s.OneOf, v, path)
+	switch val := v.(type) {
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range val {
+				errs = append(errs, validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[interface{}]interface{}:
+		fields := map[string]interface{}{}
+		for k, fv := range val {
+			fields[fmt.Sprint(k)] = fv
+		}
+		for _, name := range s.Required {
+			if _, ok := fields[name]; !ok {
+				errs = append(errs, ValidationError{path, fmt.Sprintf("missing required field %q", name)})
+			}
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			if p, ok := s.Properties[name]; ok {
+				errs = append(errs, validate(p, fields[name], fieldPath)...)
+				continue
+			}
+			switch additional := s.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, ValidationError{path, fmt.Sprintf("unknown field %q", name)})
+				}
+			case *Schema:
+				errs = append(errs, validate(additional, fields[name], fieldPath)...)
+			}
+		}
+	}
+	return errs
+}
+
+// validateOneOf checks v matches exactly one of the schemas. When it matches none, the errors
+// of the closest schema are returned, or all of them if several are as close. Schemas keyed
+// by a field with an enum (i.e. the module of an encryption config) are only considered if
+// v selects them, so the errors are those of the schema v meant to match.
+func validateOneOf(schemas []*Schema, v interface{}, path string) ValidationErrors {
+	schemas, errs := selected(schemas, v, path)
+	if len(schemas) == 0 {
+		return errs
+	}
+	matched := 0
+	var closest []ValidationErrors
+	for _, alt := range schemas {
+		altErrs := validate(alt, v, path)
+		switch {
+		case len(altErrs) == 0:
+			matched++
+		case len(closest) == 0 || len(altErrs) < len(closest[0]):
+			closest = []ValidationErrors{altErrs}
+		case len(altErrs) == len(closest[0]):
+			closest = append(closest, altErrs)
+		}
+	}
+	switch {
+	case matched == 1:
+		return ValidationErrors{}
+	case matched > 1:
+		return ValidationErrors{{path, fmt.Sprintf("must match exactly one of its %d schemas, but matched %d", len(schemas), matched)}}
+	case len(closest) == 1:
+		return closest[0]
+	}
+	msgs := make([]string, len(closest))
+	for i, altErrs := range closest {
+		msgs[i] = altErrs.Error()
+	}
+	return ValidationErrors{{"", strings.Join(msgs, ", or ")}}
+}
+
+// selected returns the schemas whose enum properties permit the fields of v, if it is an
+// object. If v selects none of them, the error lists the values it could have selected.
+// If v lacks a key, none are selected, and its schema reports the missing required field.
+func selected(schemas []*Schema, v interface{}, path string) ([]*Schema, ValidationErrors) {
+	val, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return schemas, ValidationErrors{}
+	}
+	var out []*Schema
+	keys := map[string][]interface{}{}
+	for _, alt := range schemas {
+		permitted := true
+		for name, p := range alt.Properties {
+			if len(p.Enum) == 0 {
+				continue
+			}
+			fv, ok := val[name]
+			if !ok {
+				return nil, ValidationErrors{}
+			}
+			keys[name] = append(keys[name], p.Enum...)
+			if !inEnum(fmt.Sprint(fv), p.Enum) {
+				permitted = false
+			}
+		}
+		if permitted {
+			out = append(out, alt)
+		}
+	}
+	if len(out) > 0 {
+		return out, ValidationErrors{}
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := ValidationErrors{}
+	for _, name := range names {
+		fv := val[name]
+		if inEnum(fmt.Sprint(fv), keys[name]) {
+			continue
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		allowed := make([]string, len(keys[name]))
+		for i, e := range keys[name] {
+			allowed[i] = fmt.Sprint(e)
+		}
+		errs = append(errs, ValidationError{fieldPath, fmt.Sprintf("must be one of %s, got %v", strings.Join(allowed, ", "), fv)})
+	}
+	return nil, errs
+}
+
+// hasType returns true if v, as decoded by gopkg.in/yaml.v2, is of the JSON Schema type t.
+// yaml.v2 decodes any scalar into a string (i.e. namespace: y is the string "y", not true),
+// so any scalar is accepted as a string.
+func hasType(v interface{}, t string) bool {
+	actual := typeOf(v)
+	switch t {
+	case "string":
+		return actual == "string" || actual == "boolean" || actual == "integer" || actual == "number"
+	case "number":
+		return actual == "number" || actual == "integer"
+	}
+	return actual == t
+}
+
+// typeOf returns the JSON Schema type of v, as decoded by gopkg.in/yaml.v2
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, uint64:
+		return "integer"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[interface{}]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// inEnum returns true if v is one of the string values of enum
+func inEnum(v string, enum []interface{}) bool {
+	for _, e := range enum {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,9 @@
 package types
 
+import (
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+)
+
 // DataSourceType is the type of DataSource that is represented
 type DataSourceType int
 
@@ -19,5 +23,5 @@ type DataSource interface {
 	String() string
 	Type() DataSourceType
 	OutputFormat() (OutputFormat, error)
-	Project(credsPath string) ([]byte, error)
+	Project(fsys creds.FS) ([]byte, error)
 }
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateLabels checks labels are valid Kubernetes labels, and that none of them set
+// a reserved key, like the labels the projector adds to every Secret
+func ValidateLabels(labels map[string]string, reserved ...string) error {
+	if err := checkReservedKeys("label", labels, reserved); err != nil {
+		return err
+	}
+	return metav1validation.ValidateLabels(labels, field.NewPath("labels")).ToAggregate()
+}
+
+// ValidateAnnotations checks annotations are valid Kubernetes annotations, and that none
+// of them set a reserved key, like the provenance annotation
+func ValidateAnnotations(annotations map[string]string, reserved ...string) error {
+	if err := checkReservedKeys("annotation", annotations, reserved); err != nil {
+		return err
+	}
+	return apivalidation.ValidateAnnotations(annotations, field.NewPath("annotations")).ToAggregate()
+}
+
+// checkReservedKeys returns an error naming the first (sorted) reserved key set in m
+func checkReservedKeys(kind string, m map[string]string, reserved []string) error {
+	set := []string{}
+	for _, k := range reserved {
+		if _, ok := m[k]; ok && k != "" {
+			set = append(set, k)
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	sort.Strings(set)
+	return fmt.Errorf("%s %s is reserved for the projector, and may not be set by a projection mapping", kind, set[0])
+}
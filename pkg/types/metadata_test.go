@@ -0,0 +1,38 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLabels(t *testing.T) {
+	for _, tc := range []struct {
+		labels   map[string]string
+		expected string
+	}{
+		{labels: map[string]string{"app": "web", "example.com/tier": "backend"}, expected: ""},
+		{labels: map[string]string{"bad key!": "x"}, expected: "labels: Invalid value: \"bad key!\""},
+		{labels: map[string]string{"app": "not a valid value"}, expected: "labels: Invalid value: \"not a valid value\""},
+		{labels: map[string]string{"tumblr.com/managed-secret": "false"}, expected: "label tumblr.com/managed-secret is reserved"},
+	} {
+		err := ValidateLabels(tc.labels, "tumblr.com/managed-secret", "tumblr.com/secret-version")
+		if tc.expected == "" && err != nil {
+			t.Errorf("expected %v to be valid, got %s", tc.labels, err.Error())
+		}
+		if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("expected an error containing %q validating %v, got %v", tc.expected, tc.labels, err)
+		}
+	}
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	if err := ValidateAnnotations(map[string]string{"reloader.stakater.com/match": "true", "note": "any value at all"}); err != nil {
+		t.Errorf("expected annotations to be valid, got %s", err.Error())
+	}
+	if err := ValidateAnnotations(map[string]string{"/bad": "x"}); err == nil {
+		t.Errorf("expected an invalid annotation key to fail validation")
+	}
+	if err := ValidateAnnotations(map[string]string{"tumblr.com/secret-provenance": "{}"}, "tumblr.com/secret-provenance"); err == nil {
+		t.Errorf("expected a reserved annotation to fail validation")
+	}
+}
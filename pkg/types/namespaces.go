@@ -0,0 +1,69 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNamespaceAndNamespaces is returned when a projection mapping sets both namespace and namespaces
+	ErrNamespaceAndNamespaces = errors.New("only one of namespace or namespaces may be set")
+	// ErrNoNamespace is returned when a projection mapping sets neither namespace nor namespaces
+	ErrNoNamespace = errors.New("one of namespace or namespaces must be set")
+	// ErrNoNamespacesMatched is returned when none of a projection mapping's namespaces globs match a known namespace
+	ErrNoNamespacesMatched = errors.New("namespaces matched no known namespaces")
+	// ErrNoKnownNamespaces is returned when a projection mapping uses a namespace glob, but no namespaces are known to match it against
+	ErrNoKnownNamespaces = errors.New("namespaces globs need a list of known namespaces to match (see --known-namespaces)")
+	// ErrMultipleNamespaces is returned by ProjectSecret when a projection mapping fans out to more than one namespace
+	ErrMultipleNamespaces = errors.New("projection mapping targets multiple namespaces; use ProjectSecrets")
+)
+
+// IsNamespaceGlob returns true if a namespaces entry is a glob (see path.Match), rather than a namespace
+func IsNamespaceGlob(ns string) bool {
+	return strings.ContainsAny(ns, "*?[")
+}
+
+// ResolveNamespaces returns the sorted, unique namespaces a projection mapping targets. A mapping
+// either sets a single namespace, or fans out to namespaces; each entry of namespaces is a
+// namespace, or a glob matched against the known namespaces.
+func ResolveNamespaces(namespace string, namespaces []string, known []string) ([]string, error) {
+	if len(namespaces) == 0 {
+		if namespace == "" {
+			return nil, ErrNoNamespace
+		}
+		return []string{namespace}, nil
+	}
+	if namespace != "" {
+		return nil, ErrNamespaceAndNamespaces
+	}
+	resolved := map[string]bool{}
+	for _, ns := range namespaces {
+		if !IsNamespaceGlob(ns) {
+			resolved[ns] = true
+			continue
+		}
+		if _, err := path.Match(ns, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespaces glob %s: %s", ns, err.Error())
+		}
+		if len(known) == 0 {
+			return nil, ErrNoKnownNamespaces
+		}
+		for _, k := range known {
+			if ok, _ := path.Match(ns, k); ok {
+				resolved[k] = true
+			}
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, ErrNoNamespacesMatched
+	}
+	out := make([]string, 0, len(resolved))
+	for ns := range resolved {
+		out = append(out, ns)
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,37 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveNamespaces(t *testing.T) {
+	known := []string{"web-1", "web-2", "web-canary", "api"}
+	for _, tc := range []struct {
+		namespace  string
+		namespaces []string
+		known      []string
+		expected   []string
+		err        error
+	}{
+		{"web", nil, nil, []string{"web"}, nil},
+		{"", nil, nil, nil, ErrNoNamespace},
+		{"", []string{"b", "a", "b"}, nil, []string{"a", "b"}, nil},
+		{"", []string{"web-[0-9]", "api", "other"}, known, []string{"api", "other", "web-1", "web-2"}, nil},
+		{"", []string{"web-*"}, known, []string{"web-1", "web-2", "web-canary"}, nil},
+		{"web", []string{"api"}, known, nil, ErrNamespaceAndNamespaces},
+		{"", []string{"web-*"}, nil, nil, ErrNoKnownNamespaces},
+		{"", []string{"db-*"}, known, nil, ErrNoNamespacesMatched},
+	} {
+		resolved, err := ResolveNamespaces(tc.namespace, tc.namespaces, tc.known)
+		if err != tc.err {
+			t.Errorf("expected error %v resolving %s %v, got %v", tc.err, tc.namespace, tc.namespaces, err)
+		}
+		if !reflect.DeepEqual(resolved, tc.expected) {
+			t.Errorf("expected %v resolving %s %v, got %v", tc.expected, tc.namespace, tc.namespaces, resolved)
+		}
+	}
+	if _, err := ResolveNamespaces("", []string{"web-["}, known); err == nil {
+		t.Errorf("expected an error for an invalid glob")
+	}
+}
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+	"k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/printers"
+)
+
+// ProjectSecretAsYAMLString projects the Secrets of m, and returns them as a YAML stream
+func ProjectSecretAsYAMLString(m ProjectionMapping, fsys creds.FS) (string, error) {
+	secrets, err := m.ProjectSecrets(fsys)
+	if err != nil {
+		return "", err
+	}
+	// kubernetes has some magic to YAMLify objects, so lets use that
+	// cause we cant blindly use yaml.Marshal without proper annotations
+	// on the struct fields
+	p := printers.YAMLPrinter{}
+	buf := bytes.NewBuffer([]byte{})
+	for i, sec := range secrets {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		err = p.PrintObj(sec, buf)
+		if err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// ProjectSecret projects the Secret of m, for mappings that target a single namespace
+func ProjectSecret(m ProjectionMapping, fsys creds.FS) (*v1.Secret, error) {
+	if len(m.GetNamespaces()) > 1 {
+		return nil, ErrMultipleNamespaces
+	}
+	secrets, err := m.ProjectSecrets(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return secrets[0], nil
+}
+
+// FanOut returns a copy of sec for each of namespaces, calling encrypt on each copy once its
+// namespace is set, as encrypted items are bound to the namespace they are projected to
+func FanOut(sec *v1.Secret, namespaces []string, encrypt func(*v1.Secret) error) ([]*v1.Secret, error) {
+	secrets := make([]*v1.Secret, len(namespaces))
+	for i, ns := range namespaces {
+		secrets[i] = sec.DeepCopy()
+		secrets[i].ObjectMeta.Namespace = ns
+		if err := encrypt(secrets[i]); err != nil {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+// Namespaces returns the namespaces a mapping is projected into: those resolved when it was
+// loaded, or if it was not loaded, its namespaces as written
+func Namespaces(resolved []string, namespace string, namespaces []string) []string {
+	if resolved != nil {
+		return resolved
+	}
+	if len(namespaces) > 0 {
+		return namespaces
+	}
+	return []string{namespace}
+}
+
+// FormatMapping describes a projection mapping in a single line, for logging. items
+// describe its data items.
+func FormatMapping(namespace string, namespaces []string, name string, repo string, items []string) string {
+	if len(namespaces) > 0 {
+		namespace = fmt.Sprintf("{%s}", strings.Join(namespaces, ","))
+	}
+	return fmt.Sprintf("%s/%s:%s{%s}", namespace, name, repo, strings.Join(items, ","))
+}
+
+// CopyStringMap returns a copy of in, or nil if it is empty
+func CopyStringMap(in map[string]string) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
 	"k8s.io/api/core/v1"
 )
 
@@ -10,11 +11,18 @@ import (
 // on disk
 type ProjectionMapping interface {
 	GetEncryptionConfig() conf.Encryption
+	// GetNamespace is the namespace the mapping declares, empty if it fans out to namespaces
 	GetNamespace() string
+	// GetNamespaces are all the namespaces a Secret is projected into
+	GetNamespaces() []string
 	GetName() string
 	GetRepo() string
+	// GetSourceReferences returns the creds files each data item is projected from
+	GetSourceReferences() []SourceReference
 	String() string
 	//Pluck out secret from json path and repo
-	ProjectSecret(credsPath string) (*v1.Secret, error)
-	ProjectSecretAsYAMLString(credsPath string) (string, error)
+	ProjectSecret(fsys creds.FS) (*v1.Secret, error)
+	// ProjectSecrets projects the data once, into a Secret for each of GetNamespaces()
+	ProjectSecrets(fsys creds.FS) ([]*v1.Secret, error)
+	ProjectSecretAsYAMLString(fsys creds.FS) (string, error)
 }
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFanOut(t *testing.T) {
+	sec := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	}
+	secrets, err := FanOut(sec, []string{"web", "api"}, func(s *v1.Secret) error {
+		s.Data["password"] = append([]byte(s.ObjectMeta.Namespace+":"), s.Data["password"]...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ns := range []string{"web", "api"} {
+		if secrets[i].ObjectMeta.Namespace != ns || string(secrets[i].Data["password"]) != ns+":hunter2" {
+			t.Errorf("expected a copy encrypted for %s, got %+v", ns, secrets[i])
+		}
+	}
+	if sec.ObjectMeta.Namespace != "" || string(sec.Data["password"]) != "hunter2" {
+		t.Errorf("expected the projected secret to be left alone, got %+v", sec)
+	}
+
+	failed := errors.New("failed")
+	if _, err := FanOut(sec, []string{"web"}, func(*v1.Secret) error { return failed }); err != failed {
+		t.Errorf("expected %v, got %v", failed, err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		resolved   []string
+		namespace  string
+		namespaces []string
+		expected   []string
+	}{
+		{[]string{"web-1", "web-2"}, "", []string{"web-*"}, []string{"web-1", "web-2"}},
+		{nil, "", []string{"web-*"}, []string{"web-*"}},
+		{nil, "web", nil, []string{"web"}},
+	} {
+		if ns := Namespaces(tc.resolved, tc.namespace, tc.namespaces); !reflect.DeepEqual(ns, tc.expected) {
+			t.Errorf("expected %v, got %v", tc.expected, ns)
+		}
+	}
+}
+
+func TestCopyStringMap(t *testing.T) {
+	if CopyStringMap(map[string]string{}) != nil {
+		t.Errorf("expected an empty map to copy to nil")
+	}
+	in := map[string]string{"team": "web"}
+	out := CopyStringMap(in)
+	out["team"] = "api"
+	if in["team"] != "web" {
+		t.Errorf("expected a copy, but the original was changed")
+	}
+}
@@ -0,0 +1,40 @@
+package types
+
+import (
+	"encoding/json"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+)
+
+// Provenance records where a single data item of a Secret was projected from. It never
+// includes any secret values.
+type Provenance struct {
+	Repo      string   `json:"repo"`
+	Path      string   `json:"path"`
+	JSONPaths []string `json:"jsonpaths,omitempty"`
+	// Commit is the last commit that changed Path, if the creds repo is in git
+	Commit string `json:"commit,omitempty"`
+}
+
+// NewProvenanceAnnotation returns a JSON object of data item keys to their Provenance,
+// suitable for use as the value of an annotation on a projected Secret
+func NewProvenanceAnnotation(refs []SourceReference, fsys creds.FS) (string, error) {
+	provenance := map[string]Provenance{}
+	for _, ref := range refs {
+		p := Provenance{
+			Repo:      ref.Repo,
+			Path:      ref.Path,
+			JSONPaths: ref.JSONPaths,
+		}
+		if r, ok := fsys.(creds.Revisioner); ok {
+			commit, err := r.LastCommit(ref.Path)
+			if err != nil && err != creds.ErrNotInGit {
+				return "", err
+			}
+			p.Commit = commit
+		}
+		provenance[ref.Key] = p
+	}
+	js, err := json.Marshal(provenance)
+	return string(js), err
+}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	testutil "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+)
+
+func TestProvenanceCommit(t *testing.T) {
+	dir := testutil.GitRepo(t, map[string]string{"secret.txt": "hunter2"})
+	defer os.RemoveAll(dir)
+	commit := testutil.Git(t, dir, "rev-parse", "HEAD")
+	refs := []SourceReference{{Key: "password", Repo: "production", Path: "secret.txt"}}
+
+	d, err := creds.NewDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := creds.NewGitRevision(dir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fsys := range []creds.FS{d, g} {
+		annotation, err := NewProvenanceAnnotation(refs, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provenance := map[string]Provenance{}
+		if err = json.Unmarshal([]byte(annotation), &provenance); err != nil {
+			t.Fatal(err)
+		}
+		if p := provenance["password"]; p.Commit != commit || p.Path != "secret.txt" {
+			t.Errorf("[%s] expected provenance of secret.txt at commit %s, but got %+v", fsys, commit, p)
+		}
+	}
+
+	// outside of git, there is no commit to report
+	outside, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	annotation, err := NewProvenanceAnnotation(refs, creds.Dir(outside))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(annotation, "commit") {
+		t.Errorf("expected no commit outside of git, but got %s", annotation)
+	}
+}
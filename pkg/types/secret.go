@@ -1,7 +1,11 @@
 package types
 
+import (
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+)
+
 // Secret is a group of datasources bound to a namespace
 type Secret interface {
 	String() string
-	Project(credsPath string) ([]byte, error)
+	Project(fsys creds.FS) ([]byte, error)
 }
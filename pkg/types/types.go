@@ -18,3 +18,25 @@ const (
 	// FormatYAML is the YAML output format (default for yaml sources with multiple extracted fields)
 	FormatYAML OutputFormat = "yaml"
 )
+
+// SourceReference describes where a single data item of a projected Secret is read from
+type SourceReference struct {
+	// Key is the name of the data item in the Secret
+	Key string
+	// Repo is the identifier of the creds repo the source is read from
+	Repo string
+	// Path is the path of the source file, relative to the creds repo
+	Path string
+	// JSONPaths are the fields extracted from a structured source, if any
+	JSONPaths []string
+}
+
+const (
+	// ProjectionMappingKind is the kind of every projection mapping document
+	ProjectionMappingKind = "ProjectionMapping"
+	// APIVersionV1 is the apiVersion of v1 projection mappings. Documents without an
+	// apiVersion are v1, as they predate versioning.
+	APIVersionV1 = "secret-projector.tumblr.com/v1"
+	// APIVersionV2 is the apiVersion of v2 projection mappings
+	APIVersionV2 = "secret-projector.tumblr.com/v2"
+)
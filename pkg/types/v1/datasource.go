@@ -4,14 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/ghodss/yaml"
 	"github.com/oliveagle/jsonpath"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
 	"github.com/tumblr/k8s-secret-projector/pkg/types"
 )
 
@@ -26,23 +26,27 @@ var (
 	ErrMissingJSONPathSelector = errors.New("either JSONPath or JSONPaths need to be defined")
 	// ErrMultipleJSONPathSelector is thrown when a structured projection specifies both jsonpath and jsonpaths
 	ErrMultipleJSONPathSelector = errors.New("only JSONPath or JSONPaths need to be defined")
+	// ErrAbsoluteSourcePath is thrown when a datasource references an absolute path, instead of a path relative to the creds repo
+	ErrAbsoluteSourcePath = creds.ErrAbsolutePath
+	// ErrSourcePathOutsideCredsRepo is thrown when a datasource path (or a symlink it traverses) resolves outside of the creds repo
+	ErrSourcePathOutsideCredsRepo = creds.ErrPathOutsideRepo
 )
 
 // DataSource is a source of data that will be projected into a secret
 // it specifies its source, output format (optional), and fields to extract
 // from the source.
 type DataSource struct {
-	JSON string `json:"json,omitempty",yaml:"json,omitempty"`
-	YAML string `json:"yaml,omitempty",yaml:"yaml,omitempty"`
-	Raw  string `json:"raw,omitempty",yaml:"raw,omitempty"`
+	JSON string `json:"json,omitempty" yaml:"json,omitempty"`
+	YAML string `json:"yaml,omitempty" yaml:"yaml,omitempty"`
+	Raw  string `json:"raw,omitempty" yaml:"raw,omitempty"`
 	// Format is the desired output format for the secret. This defaults to the input format
 	// unless overridden. See OutputFormat()
-	Format   types.OutputFormat `json:"format,omitempty",yaml:"format,omitempty"`
-	JSONPath string             `json:"jsonpath,omitempty",yaml:"jsonpath,omitempty"`
+	Format   types.OutputFormat `json:"format,omitempty" yaml:"format,omitempty"`
+	JSONPath string             `json:"jsonpath,omitempty" yaml:"jsonpath,omitempty"`
 	// JSONPaths is different from the singular path, it represents one (or more) json elements
 	// being selected from a datasource and exported into a single file
 	// the key is the label it should be defined as, the value is the jsonpath
-	JSONPaths map[string]types.JSONPathSelector `json:"jsonpath,omitempty",yaml:"jsonpath,omitempty"`
+	JSONPaths map[string]types.JSONPathSelector `json:"jsonpaths,omitempty" yaml:"jsonpaths,omitempty"`
 }
 
 // String returns a string representation of the datasource
@@ -121,22 +125,55 @@ func (d *DataSource) Type() types.DataSourceType {
 	return types.UnknownType
 }
 
+// Path returns the path of the source file, relative to the creds repo
+func (d *DataSource) Path() string {
+	switch d.Type() {
+	case types.JSONType:
+		return d.JSON
+	case types.YAMLType:
+		return d.YAML
+	case types.RawType:
+		return d.Raw
+	default:
+		return ""
+	}
+}
+
+// Selectors returns the JSONPath selectors extracted from the source, sorted
+func (d *DataSource) Selectors() []string {
+	if d.JSONPath != "" {
+		return []string{d.JSONPath}
+	}
+	selectors := []string{}
+	for _, p := range d.JSONPaths {
+		selectors = append(selectors, string(p))
+	}
+	sort.Strings(selectors)
+	return selectors
+}
+
+// Validate checks that the source path is confined to the creds repo it will be
+// resolved against. Symlinks are checked later, when the source is projected.
+func (d *DataSource) Validate() error {
+	return creds.ValidPath(d.Path())
+}
+
 // Project will resolve the data pointed to by this DataSource, and
 // return the data referenced by it as a string
-func (d *DataSource) Project(credsPath string) ([]byte, error) {
+func (d *DataSource) Project(fsys creds.FS) ([]byte, error) {
 	switch d.Type() {
 	case types.JSONType:
-		return d.projectJSON(credsPath)
+		return d.projectJSON(fsys)
 	case types.YAMLType:
-		return d.projectYAML(credsPath)
+		return d.projectYAML(fsys)
 	case types.RawType:
-		return d.projectRaw(credsPath)
+		return d.projectRaw(fsys)
 	default:
 		return nil, fmt.Errorf("unable to project unknown type datasource")
 	}
 }
 
-func (d *DataSource) projectRaw(credsPath string) ([]byte, error) {
+func (d *DataSource) projectRaw(fsys creds.FS) ([]byte, error) {
 	format, err := d.OutputFormat()
 	if err != nil {
 		return nil, err
@@ -145,11 +182,11 @@ func (d *DataSource) projectRaw(credsPath string) ([]byte, error) {
 		return nil, ErrUnsupportedOutputFormat
 	}
 	// just read the file, and return it as a []byte
-	bytes, err := ioutil.ReadFile(filepath.Join(credsPath, d.Raw))
+	bytes, err := fsys.ReadFile(d.Raw)
 	return bytes, err
 }
 
-func (d *DataSource) projectJSON(credsPath string) ([]byte, error) {
+func (d *DataSource) projectJSON(fsys creds.FS) ([]byte, error) {
 	format, err := d.OutputFormat()
 	if err != nil {
 		return nil, err
@@ -168,7 +205,7 @@ func (d *DataSource) projectJSON(credsPath string) ([]byte, error) {
 
 	// read the JSON source file
 	var jsonData interface{}
-	bytes, err := ioutil.ReadFile(filepath.Join(credsPath, d.JSON))
+	bytes, err := fsys.ReadFile(d.JSON)
 	if err != nil {
 		return nil, err
 	}
@@ -212,7 +249,7 @@ func (d *DataSource) projectJSON(credsPath string) ([]byte, error) {
 	}
 }
 
-func (d *DataSource) projectYAML(credsPath string) ([]byte, error) {
+func (d *DataSource) projectYAML(fsys creds.FS) ([]byte, error) {
 	format, err := d.OutputFormat()
 	if err != nil {
 		return nil, err
@@ -231,9 +268,9 @@ func (d *DataSource) projectYAML(credsPath string) ([]byte, error) {
 
 	// read the YAML file
 	var yamlData interface{}
-	bytes, err := ioutil.ReadFile(filepath.Join(credsPath, d.YAML))
+	bytes, err := fsys.ReadFile(d.YAML)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read file %s: %s", filepath.Join(credsPath, d.YAML), err)
+		return nil, fmt.Errorf("cannot read file %s: %s", d.YAML, err)
 	}
 	err = yaml.Unmarshal(bytes, &yamlData)
 	if err != nil {
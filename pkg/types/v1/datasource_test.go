@@ -2,18 +2,68 @@ package v1
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
 	"github.com/tumblr/k8s-secret-projector/pkg/types"
 )
 
 var (
-	credsPath          = "test/fixtures/files"
-	credsEncryptionKey = "test/fixtures/files/encryption-cbc-key.json"
-	jsonTestFile       = "object1.json"
-	yamlTestFile       = "object1.yaml"
-	rawTestFile        = "raw1.txt"
+	// credsFS holds the creds tests project from, so tests dont depend on fixtures on disk
+	credsFS = creds.MemFS{
+		"object1.json": []byte(`{
+  "secret": "paSsw0rd!",
+  "listroot": ["sdfjsklfsjklsjfsdlkfjsdl"],
+  "nesting": {
+    "key1": "foo",
+    "list": ["abc","def","ghi"],
+    "list2": ["foobar"],
+    "list-int": [1,2,3],
+    "list-float": [69.0, 420.69],
+    "list-string": ["foo", "bar"],
+    "float": 1.23,
+    "int": 12345,
+    "bool": true,
+    "map": {
+      "foo": "bar",
+      "baz": 666
+    }
+  }
+}
+`),
+		"object1.yaml": []byte(`secret: "paSsw0rd!"
+nesting:
+  key1: "foo"
+  integer: 420
+  float: -69.6969
+  bool: true
+  list:
+  - abc
+  - def
+  - ghi
+  list-int: [1,2,3]
+  list-float:
+    - 69.0
+    - 420.69
+  list-string:
+    - foo
+    - bar
+  map:
+    foo: bar
+    baz: 123
+`),
+		"raw1.txt": []byte("hello\nthis is a raw file\n"),
+	}
+	credsEncryptionKey    = "test/fixtures/files/encryption-cbc-key.json"
+	credsKeyDecryptionKey = "test/fixtures/files/aes_params_test_3.json"
+	jsonTestFile          = "object1.json"
+	yamlTestFile          = "object1.yaml"
+	rawTestFile           = "raw1.txt"
 )
 
 func TestDataSourceTypes(t *testing.T) {
@@ -32,7 +82,7 @@ func TestDataSourceTypes(t *testing.T) {
 }
 func TestProjectRaw(t *testing.T) {
 	d := DataSource{Raw: rawTestFile}
-	x, err := d.Project(credsPath)
+	x, err := d.Project(credsFS)
 	if err != nil {
 		t.Error(err)
 	}
@@ -45,7 +95,7 @@ func TestProjectRaw(t *testing.T) {
 
 func TestProjectRawMissingFile(t *testing.T) {
 	d := DataSource{Raw: "file/doesnt/exist.txt"}
-	_, err := d.Project(credsPath)
+	_, err := d.Project(credsFS)
 	if err == nil {
 		t.Fatal("expected error for file not existing, but didnt get one")
 	}
@@ -67,7 +117,7 @@ var jsonTests = map[string]string{
 func TestProjectJSON(t *testing.T) {
 	for path, expected := range jsonTests {
 		d := DataSource{JSON: jsonTestFile, JSONPath: path}
-		x, err := d.Project(credsPath)
+		x, err := d.Project(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -80,14 +130,14 @@ func TestProjectJSON(t *testing.T) {
 
 func TestProjectJSONPathsError(t *testing.T) {
 	emptyData := DataSource{JSON: jsonTestFile, JSONPaths: map[string]types.JSONPathSelector{}}
-	_, err := emptyData.projectJSON(credsPath)
+	_, err := emptyData.projectJSON(credsFS)
 
 	if err == nil {
 		t.Fatal("should expect to fail on an empty map for JSONPaths")
 	}
 
 	badKey := DataSource{JSON: jsonTestFile, JSONPaths: map[string]types.JSONPathSelector{"invalidKey": "invalidKey"}}
-	_, err = badKey.projectJSON(credsPath)
+	_, err = badKey.projectJSON(credsFS)
 
 	if err == nil {
 		t.Fatal("should fail on bad key")
@@ -109,7 +159,7 @@ secret: paSsw0rd!
 			"secret": "$.secret", "bool": "$.nesting.bool", "listlabel": "$.nesting.list"}},
 	}
 	for expected, d := range testSources {
-		x, err := d.projectYAML(credsPath)
+		x, err := d.projectYAML(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -134,7 +184,7 @@ secret: paSsw0rd!
 			"secret": "$.secret", "bool": "$.nesting.bool", "listlabel": "$.nesting.list"}},
 	}
 	for expected, d := range testSources {
-		x, err := d.projectJSON(credsPath)
+		x, err := d.projectJSON(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -148,7 +198,7 @@ func TestProjectJSONUnknownType(t *testing.T) {
 	jsonpaths := []string{"$.nesting.list-int", "$.nesting.map", "$.nesting.list-float"}
 	for _, path := range jsonpaths {
 		d := DataSource{JSON: jsonTestFile, JSONPath: path}
-		_, err := d.Project(credsPath)
+		_, err := d.Project(credsFS)
 		if err == nil {
 			t.Fatalf("expected projecting %s would fail, but got no error", path)
 		}
@@ -171,7 +221,7 @@ var yamlTests = map[string]string{
 func TestProjectYAML(t *testing.T) {
 	for path, expected := range yamlTests {
 		d := DataSource{YAML: yamlTestFile, JSONPath: path}
-		x, err := d.Project(credsPath)
+		x, err := d.Project(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -185,7 +235,7 @@ func TestProjectYAMLUnknownType(t *testing.T) {
 	jsonpaths := []string{"$.nesting.list-int", "$.nesting.map", "$.nesting.list-float"}
 	for _, path := range jsonpaths {
 		d := DataSource{YAML: yamlTestFile, JSONPath: path}
-		_, err := d.Project(credsPath)
+		_, err := d.Project(credsFS)
 		if err == nil {
 			t.Fatalf("expected projecting %s would fail, but got no error", path)
 		}
@@ -246,3 +296,63 @@ func TestStructuredDataSourceOutputFormatInference(t *testing.T) {
 		}
 	}
 }
+
+/** creds repo confinement tests **/
+
+func TestProjectRejectsPathsOutsideCredsRepo(t *testing.T) {
+	paths := []string{"../files/raw1.txt", "../../README.md", "nesting/../../raw1.txt", "/etc/passwd"}
+	for _, p := range paths {
+		d := DataSource{Raw: p}
+		if err := d.Validate(); err == nil {
+			t.Errorf("expected validating %s would fail, but got no error", p)
+		}
+		if _, err := d.Project(credsFS); err == nil {
+			t.Errorf("expected projecting %s would fail, but got no error", p)
+		}
+	}
+	d := DataSource{Raw: "nesting/../raw1.txt"}
+	if err := d.Validate(); err != nil {
+		t.Errorf("expected nesting/../raw1.txt to be valid, but got %s", err.Error())
+	}
+}
+
+func TestProjectRejectsSymlinkEscape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outsideDir, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outsideDir)
+	outside := filepath.Join(outsideDir, rawTestFile)
+	if err := ioutil.WriteFile(outside, []byte("outside"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "escape.txt")); err != nil {
+		t.Skipf("unable to create symlink: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "inside.txt"), []byte("inside"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("inside.txt", filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := DataSource{Raw: "escape.txt"}
+	_, err = d.Project(creds.Dir(dir))
+	if err == nil || !strings.Contains(err.Error(), ErrSourcePathOutsideCredsRepo.Error()) {
+		t.Fatalf("expected projecting a symlink out of the creds repo to fail with '%s', but got %v", ErrSourcePathOutsideCredsRepo, err)
+	}
+
+	d = DataSource{Raw: "link.txt"}
+	x, err := d.Project(creds.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(x) != "inside" {
+		t.Fatalf("expected symlink within the creds repo to project 'inside', but got %s", x)
+	}
+}
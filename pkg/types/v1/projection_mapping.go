@@ -1,18 +1,17 @@
 package v1
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
 	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
 	"github.com/tumblr/k8s-secret-projector/pkg/types"
 	"gopkg.in/yaml.v2"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/kubernetes/pkg/printers"
 )
 
 var (
@@ -22,45 +21,53 @@ var (
 	ErrEncryptionRequestedButNoEncryptionConfigSpecified = fmt.Errorf("encryption of a data element was requested, but no encryption_config was found to instantiate an encryptio      n module")
 	// DecryptionKeysPrefix is the prefix used for injecting the decryption keys JSON into a Secret when Encryption.IncludeDecryptionKeys is true
 	DecryptionKeysPrefix = "keys_"
+	// ErrWrongAPIVersion is returned when a document with another apiVersion is loaded as v1
+	ErrWrongAPIVersion = fmt.Errorf("projection mapping apiVersion must be %s", types.APIVersionV1)
+	// ErrWrongKind is returned when a document is not a projection mapping
+	ErrWrongKind = fmt.Errorf("projection mapping kind must be %s", types.ProjectionMappingKind)
 )
 
 // ProjectionMapping is a v1 implementation of the struct that joins
 // a declaration of dependency on a set of secrets, with the secrets
 // sourced from a secret repository
 type ProjectionMapping struct {
-	Name       string          `json:"name",yaml:"name"`
-	Namespace  string          `json:"namespace",yaml:"namespace"`
-	Repo       string          `json:"repo",yaml:"repo"`
-	Data       []Secret        `json:"data",yaml:"data"` //data:
-	Encryption conf.Encryption `yaml:"encryption",json:"encryption"`
+	// APIVersion and Kind are optional for v1, which predates versioning
+	APIVersion string `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Name       string `json:"name" yaml:"name"`
+	Namespace  string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Namespaces fans the Secret out to many namespaces, instead of Namespace. Entries may be globs
+	// matched against the known namespaces.
+	Namespaces []string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	Repo       string   `json:"repo" yaml:"repo"`
+	// Labels and Annotations are set on the projected Secret, alongside any the projector adds
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Data        []Secret          `json:"data" yaml:"data"` //data:
+	Encryption  conf.Encryption   `yaml:"encryption,omitempty" json:"encryption,omitempty"`
 
 	crypter encryption.Module
 	c       conf.Config
+	// namespaces are the resolved Namespaces
+	namespaces []string
 }
 
 // LoadFromYamlBytes parses a ProjectionMapping from a string
 func LoadFromYamlBytes(raw []byte, cfg conf.Config) (types.ProjectionMapping, error) {
-	/**
-		var aux struct {
-			Name      string `yaml:"name"`
-			Namespace string `yaml:"namespace"`
-			Data      []struct {
-				Name   string `yaml:"name"`
-				Source struct {
-					JSON     string `yaml:"json"`
-					YAML     string `yaml:"yaml"`
-					Raw      string `yaml:"raw"`
-					JSONPath string `yaml:"jsonpath"`
-				} `yaml:"source"`
-			} `yaml:"data"`
-		}
-	  **/
-	var m ProjectionMapping
-	m.c = cfg
-	err := yaml.UnmarshalStrict(raw, &m)
+	m, err := ParseYamlBytes(raw)
 	if err != nil {
 		return nil, err
 	}
+	m.c = cfg
+	if m.namespaces, err = types.ResolveNamespaces(m.Namespace, m.Namespaces, cfg.KnownNamespaces()); err != nil {
+		return nil, err
+	}
+	if err = types.ValidateLabels(m.Labels, cfg.LabelVersionKey(), cfg.LabelManagedKey()); err != nil {
+		return nil, err
+	}
+	if err = types.ValidateAnnotations(m.Annotations, cfg.AnnotationProvenanceKey()); err != nil {
+		return nil, err
+	}
 
 	// setup the crypter. if no module requested, skip setting this up (we will bail if any items asked to be
 	// encrypted but didnt specify the module)
@@ -79,51 +86,91 @@ func LoadFromYamlBytes(raw []byte, cfg conf.Config) (types.ProjectionMapping, er
 		}
 		m.crypter = c
 	}
+	return m, nil
+}
+
+// ParseYamlBytes parses and validates a ProjectionMapping, without binding it to a
+// Config. The result can be inspected or converted, but not projected.
+func ParseYamlBytes(raw []byte) (*ProjectionMapping, error) {
+	var m ProjectionMapping
+	err := yaml.UnmarshalStrict(raw, &m)
+	if err != nil {
+		return nil, err
+	}
+	if m.APIVersion != "" && m.APIVersion != types.APIVersionV1 {
+		return nil, ErrWrongAPIVersion
+	}
+	if m.Kind != "" && m.Kind != types.ProjectionMappingKind {
+		return nil, ErrWrongKind
+	}
+	for _, s := range m.Data {
+		if err := s.Source.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid source for data item %s: %s", s.Name, err.Error())
+		}
+	}
 	return &m, nil
 }
 
 // ProjectSecretAsYAMLString will take a ProjectionMapping and return the k8s secret resource
 // as a YAML representation in string form
-func (m *ProjectionMapping) ProjectSecretAsYAMLString(credsPath string) (string, error) {
-	sec, err := m.ProjectSecret(credsPath)
-	if err != nil {
-		return "", err
-	}
-	// kubernetes has some magic to YAMLify objects, so lets use that
-	// cause we cant blindly use yaml.Marshal without proper annotations
-	// on the struct fields
-	p := printers.YAMLPrinter{}
-	buf := bytes.NewBuffer([]byte{})
-	err = p.PrintObj(sec, buf)
+func (m *ProjectionMapping) ProjectSecretAsYAMLString(fsys creds.FS) (string, error) {
+	return types.ProjectSecretAsYAMLString(m, fsys)
+}
+
+// ProjectSecret will take a ProjectionMapping and return the k8s secret resource,
+// reading its data sources from the creds repo fsys. Mappings that fan out to more
+// than one namespace must use ProjectSecrets.
+func (m *ProjectionMapping) ProjectSecret(fsys creds.FS) (*v1.Secret, error) {
+	return types.ProjectSecret(m, fsys)
+}
+
+// ProjectSecrets projects the data sources once, and returns a copy of the k8s secret
+// resource for each namespace the ProjectionMapping targets. Encrypted items are
+// encrypted for each namespace, as they are bound to the namespace they are projected to
+func (m *ProjectionMapping) ProjectSecrets(fsys creds.FS) ([]*v1.Secret, error) {
+	sec, err := m.projectSecret(fsys)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return buf.String(), nil
+	return types.FanOut(sec, m.GetNamespaces(), m.encryptData)
 }
 
-// ProjectSecret will take a ProjectionMapping and return the k8s secret resource
-func (m *ProjectionMapping) ProjectSecret(credsPath string) (*v1.Secret, error) {
+// encryptData encrypts the items of sec that request encryption, binding them to
+// the namespace, name and key they are projected to
+func (m *ProjectionMapping) encryptData(sec *v1.Secret) error {
+	for _, s := range m.Data {
+		if !s.Encrypt {
+			continue
+		}
+		ed, err := m.crypter.Encrypt(sec.Data[s.Name], aad.Context{
+			Namespace: sec.ObjectMeta.Namespace,
+			Name:      sec.ObjectMeta.Name,
+			Key:       s.Name,
+		})
+		if err != nil {
+			return err
+		}
+		sec.Data[s.Name] = ed
+	}
+	return nil
+}
+
+// projectSecret reads the data sources from the creds repo fsys into a k8s secret resource,
+// without a namespace, and with its data unencrypted
+func (m *ProjectionMapping) projectSecret(fsys creds.FS) (*v1.Secret, error) {
 	data := map[string][]byte{}
 	// the k8s v1.Secret is a combination of all its Secret's datasources
 	// so project each one, into the v1.Secret
 
 	for _, s := range m.Data {
-		d, err := s.Project(credsPath)
+		d, err := s.Project(fsys)
 		if err != nil {
 			return nil, err
 		}
 		if s.Encrypt && m.crypter == nil {
 			return nil, ErrEncryptionRequestedButNoEncryptionConfigSpecified
 		}
-		if s.Encrypt {
-			ed, err := m.crypter.Encrypt(d)
-			if err != nil {
-				return nil, err
-			}
-			data[s.Name] = ed
-		} else {
-			data[s.Name] = d
-		}
+		data[s.Name] = d
 	}
 	// include decryption keys if requested in the generated Secret
 	if m.crypter != nil && m.Encryption.IncludeDecryptionKeys {
@@ -141,8 +188,9 @@ func (m *ProjectionMapping) ProjectSecret(credsPath string) (*v1.Secret, error)
 	}
 	sekrit := v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name,
-			Namespace: m.Namespace,
+			Name:        m.Name,
+			Labels:      types.CopyStringMap(m.Labels),
+			Annotations: types.CopyStringMap(m.Annotations),
 		},
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Secret",
@@ -152,10 +200,21 @@ func (m *ProjectionMapping) ProjectSecret(credsPath string) (*v1.Secret, error)
 		Data: data,
 	}
 	if m.c.AddDeployLabels() {
-		sekrit.ObjectMeta.Labels = map[string]string{
-			m.c.LabelVersionKey(): m.c.Generation(),
-			m.c.LabelManagedKey(): "true",
+		if sekrit.ObjectMeta.Labels == nil {
+			sekrit.ObjectMeta.Labels = map[string]string{}
+		}
+		sekrit.ObjectMeta.Labels[m.c.LabelVersionKey()] = m.c.Generation()
+		sekrit.ObjectMeta.Labels[m.c.LabelManagedKey()] = "true"
+	}
+	if m.c.AddProvenanceAnnotations() {
+		provenance, err := types.NewProvenanceAnnotation(m.GetSourceReferences(), fsys)
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine provenance of %s: %s", m.Name, err.Error())
+		}
+		if sekrit.ObjectMeta.Annotations == nil {
+			sekrit.ObjectMeta.Annotations = map[string]string{}
 		}
+		sekrit.ObjectMeta.Annotations[m.c.AnnotationProvenanceKey()] = provenance
 	}
 	return &sekrit, nil
 }
@@ -165,7 +224,7 @@ func (m *ProjectionMapping) String() string {
 	for i, s := range m.Data {
 		data[i] = s.String()
 	}
-	return fmt.Sprintf("%s/%s:%s{%s}", m.Namespace, m.Name, m.Repo, strings.Join(data, ","))
+	return types.FormatMapping(m.Namespace, m.Namespaces, m.Name, m.Repo, data)
 }
 
 // GetEncryptionConfig is the encryption configuration for this projection
@@ -173,11 +232,17 @@ func (m *ProjectionMapping) GetEncryptionConfig() conf.Encryption {
 	return m.Encryption
 }
 
-// GetNamespace is the namespace the ProjectionMapping is bound to
+// GetNamespace is the namespace the ProjectionMapping is bound to, empty if it fans out to Namespaces
 func (m *ProjectionMapping) GetNamespace() string {
 	return m.Namespace
 }
 
+// GetNamespaces are the namespaces the ProjectionMapping is projected into, with any globs
+// resolved when it was loaded
+func (m *ProjectionMapping) GetNamespaces() []string {
+	return types.Namespaces(m.namespaces, m.Namespace, m.Namespaces)
+}
+
 // GetName is the name of a ProjectionMapping
 func (m *ProjectionMapping) GetName() string {
 	return m.Name
@@ -187,3 +252,17 @@ func (m *ProjectionMapping) GetName() string {
 func (m *ProjectionMapping) GetRepo() string {
 	return m.Repo
 }
+
+// GetSourceReferences returns the creds files each data item is projected from
+func (m *ProjectionMapping) GetSourceReferences() []types.SourceReference {
+	refs := make([]types.SourceReference, len(m.Data))
+	for i, s := range m.Data {
+		refs[i] = types.SourceReference{
+			Key:       s.Name,
+			Repo:      m.Repo,
+			Path:      s.Source.Path(),
+			JSONPaths: s.Source.Selectors(),
+		}
+	}
+	return refs
+}
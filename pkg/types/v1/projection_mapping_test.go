@@ -1,23 +1,29 @@
 package v1
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 
 	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing" // hack to make test fixtures non-relative
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
 	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
 )
 
 var (
 	testEncryptionConfigCbc = conf.Encryption{
-		Module:                "cbc",
-		IncludeDecryptionKeys: true,
-		CredsKeysFilePath:     credsEncryptionKey,
+		Module:            "cbc",
+		CredsKeysFilePath: credsEncryptionKey,
 	}
 	relManifestsPath = "test/fixtures/manifests"
 	relFixtures      = "test/fixtures"
@@ -79,10 +85,12 @@ func readFixtureSecret(n string) string {
 type TestConfig struct {
 	credsEncryptionKeyFile    string
 	credsKeyDecryptionKeyFile string
+	addProvenanceAnnotations  bool
+	knownNamespaces           []string
 }
 
 func (c *TestConfig) CredsKeyDecryptionKeyFile() string {
-	return ""
+	return c.credsKeyDecryptionKeyFile
 }
 func (c *TestConfig) CredsEncryptionKeyFile() string {
 	return c.credsEncryptionKeyFile
@@ -100,6 +108,22 @@ func (c *TestConfig) ProjectionMappingsRootPath() string {
 	return ""
 }
 
+func (c *TestConfig) AccessPolicy() *conf.AccessPolicy {
+	return nil
+}
+
+func (c *TestConfig) ChangedCredsFiles() map[string][]string {
+	return map[string][]string{}
+}
+
+func (c *TestConfig) ChangedCredsRevisions() map[string]string {
+	return map[string]string{}
+}
+
+func (c *TestConfig) KnownNamespaces() []string {
+	return c.knownNamespaces
+}
+
 func (c *TestConfig) Debug() bool {
 	return false
 }
@@ -123,6 +147,14 @@ func (c *TestConfig) AddDeployLabels() bool {
 	return true
 }
 
+func (c *TestConfig) AddProvenanceAnnotations() bool {
+	return c.addProvenanceAnnotations
+}
+
+func (c *TestConfig) AnnotationProvenanceKey() string {
+	return "test/provenance"
+}
+
 func (c *TestConfig) LabelVersionKey() string {
 	return "test/tumblr-version"
 }
@@ -138,12 +170,13 @@ func (c *TestConfig) Generation() string {
 func TestSecret_Project_Plugin_Encryption_WithKeys(t *testing.T) {
 	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
 		c := getTestConfig()
+		c.credsKeyDecryptionKeyFile = credsKeyDecryptionKey
 
 		var tests = map[string]map[string][]string{
 			"plugin-cbc-enc-withdecryptkeys": map[string][]string{
 				// data:  [expected, actual]
 				"secrets.json.enc": []string{`{"float":1.23,"key1":"foo","list":["abc","def","ghi"]}`, ""},
-				"keys_1.json":      []string{`{"password":"ell0_OliV3r!"}`, ""},
+				"keys_1.json":      []string{`{"id":"ca90db32b87fdf85","password":"ell0_OliV3r!","kdf":{"name":"legacy","hash":"md5"},"active":true}`, ""},
 			},
 		}
 
@@ -161,16 +194,15 @@ func TestSecret_Project_Plugin_Encryption_WithKeys(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			secret, err := m.ProjectSecret(credsPath)
+			secret, err := m.ProjectSecret(credsFS)
 
 			if err != nil {
 				t.Fatal(err.Error())
 			}
 			e, err := encryption.NewModuleFromEncryptionConfig(conf.Encryption{
-				Module:                "plugin",
-				PluginPath:            "cbc.so",
-				IncludeDecryptionKeys: true,
-				CredsKeysFilePath:     credsEncryptionKey,
+				Module:            "plugin",
+				PluginPath:        "bin/plugins/cbc",
+				CredsKeysFilePath: credsEncryptionKey,
 			})
 
 			if err != nil {
@@ -184,12 +216,14 @@ func TestSecret_Project_Plugin_Encryption_WithKeys(t *testing.T) {
 				if filepath.Ext(k) == ".enc" {
 					// first try to decrypt, then
 					// store the decrypted value as actual val
-					d, err := e.Decrypt(v)
+					d, err := e.Decrypt(v, aad.Context{Namespace: secret.Namespace, Name: secret.Name, Key: k})
 					if err != nil {
 						t.Fatalf("failed to decrypt %s: %s", k, err.Error())
 					}
 					t.Logf("encryption/decryption successful %s %s", k, d)
 					tests[test][k][1] = string(d)
+				} else if strings.HasPrefix(k, DecryptionKeysPrefix) {
+					tests[test][k][1] = unwrapKeyJSON(t, v)
 				} else {
 					// store the raw value as actual
 					tests[test][k][1] = string(v)
@@ -242,7 +276,7 @@ func TestSecret_Project_Plugin_Encryption_OmitKeys(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			secret, err := m.ProjectSecret(credsPath)
+			secret, err := m.ProjectSecret(credsFS)
 
 			if err != nil {
 				t.Fatal(err.Error())
@@ -262,7 +296,7 @@ func TestSecret_Project_Plugin_Encryption_OmitKeys(t *testing.T) {
 					// first try to decrypt, then
 					// store the decrypted value as actual val
 					t.Logf("[%s] decryption item: %s", test, k)
-					d, err := e.Decrypt(v)
+					d, err := e.Decrypt(v, aad.Context{Namespace: secret.Namespace, Name: secret.Name, Key: k})
 					if err != nil {
 						t.Fatalf("failed to decrypt %s: %s", k, err.Error())
 					}
@@ -302,12 +336,13 @@ func TestSecret_Project_Plugin_Encryption_OmitKeys(t *testing.T) {
 
 func TestSecret_Project_Encryption_CBC(t *testing.T) {
 	c := getTestConfig()
-	// expected/actual values for the keys_N.json values
+	c.credsKeyDecryptionKeyFile = credsKeyDecryptionKey
+	// expected/actual values for the keys_N.json values, once unwrapped
 	var tests = map[string]map[string][]string{
 		"plugin-cbc-enc-withdecryptkeys": map[string][]string{
 			// data:  [expected, actual]
 			"secrets.json.enc": []string{`{"float":1.23,"key1":"foo","list":["abc","def","ghi"]}`, ""},
-			"keys_1.json":      []string{`{"password":"ell0_OliV3r!"}`, ""},
+			"keys_1.json":      []string{`{"id":"ca90db32b87fdf85","password":"ell0_OliV3r!","kdf":{"name":"legacy","hash":"md5"},"active":true}`, ""},
 		},
 	}
 
@@ -322,7 +357,7 @@ func TestSecret_Project_Encryption_CBC(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		secret, err := m.ProjectSecret(credsPath)
+		secret, err := m.ProjectSecret(credsFS)
 
 		if err != nil {
 			t.Fatal(err.Error())
@@ -336,12 +371,14 @@ func TestSecret_Project_Encryption_CBC(t *testing.T) {
 			if filepath.Ext(k) == ".enc" {
 				// first try to decrypt, then
 				// store the decrypted value as actual val
-				d, err := e.Decrypt(v)
+				d, err := e.Decrypt(v, aad.Context{Namespace: secret.Namespace, Name: secret.Name, Key: k})
 				if err != nil {
 					t.Fatalf("failed to decrypt %s: %s", k, err.Error())
 				}
 				t.Logf("encryption/decryption successful %s %s", k, d)
 				tests[test][k][1] = string(d)
+			} else if strings.HasPrefix(k, DecryptionKeysPrefix) {
+				tests[test][k][1] = unwrapKeyJSON(t, v)
 			} else {
 				// store the raw value as actual
 				tests[test][k][1] = string(v)
@@ -379,11 +416,11 @@ func TestJSONManifest(t *testing.T) {
 		if m.String() != testManifestStrings[test] {
 			t.Fatalf("Expected %s.String() to be %s, got %s", test, m.String(), testManifestStrings[test])
 		}
-		_, err = m.ProjectSecret(credsPath)
+		_, err = m.ProjectSecret(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
-		yamlString, err := m.ProjectSecretAsYAMLString(credsPath)
+		yamlString, err := m.ProjectSecretAsYAMLString(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -410,12 +447,12 @@ func TestRawManifest(t *testing.T) {
 		t.Fatalf("Expected %s.String() to be %s, got %s", test, m.String(), testManifestStrings[test])
 	}
 
-	_, err = m.ProjectSecret(credsPath)
+	_, err = m.ProjectSecret(credsFS)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	yamlString, err := m.ProjectSecretAsYAMLString(credsPath)
+	yamlString, err := m.ProjectSecretAsYAMLString(credsFS)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -427,7 +464,7 @@ func TestRawManifest(t *testing.T) {
 func TestMissingEncryptionPluginPath(t *testing.T) {
 	// we shouldnt be able to load a manifest with a missing plugin
 	test := "missing-pluginpath-1"
-	expectedErr := `plugin.Open("plugin-missing.so"): realpath failed`
+	expectedErr := "unable to start plugin bin/plugins/missing: "
 	config := getTestConfig()
 	path := testManifests[test]
 	data, err := ioutil.ReadFile(path)
@@ -438,7 +475,7 @@ func TestMissingEncryptionPluginPath(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error, but got none")
 	}
-	if err.Error() != expectedErr {
+	if !strings.HasPrefix(err.Error(), expectedErr) {
 		t.Fatalf("expected error %s but got %s", expectedErr, err.Error())
 	}
 }
@@ -458,9 +495,9 @@ func TestMissingSourceManifest(t *testing.T) {
 	if m.String() != testManifestStrings[test] {
 		t.Fatalf("Expected %s.String() to be %s, got %s", test, m.String(), testManifestStrings[test])
 	}
-	_, err = m.ProjectSecret(credsPath)
-	if err.Error() != "open test/fixtures/files/test/doesnt-exist.txt: no such file or directory" {
-		t.Fatalf("expected unable to open up non-existent file, but didnt error correctly. Got: %s", err.Error())
+	_, err = m.ProjectSecret(credsFS)
+	if err == nil || !os.IsNotExist(err) {
+		t.Fatalf("expected unable to open up non-existent file, but didnt error correctly. Got: %v", err)
 	}
 }
 
@@ -487,7 +524,7 @@ func TestStructuredProjection(t *testing.T) {
 			t.Fatalf("Expected %s.String() to be %s, got %s", test, m.String(), testManifestStrings[test])
 		}
 
-		d, err := m.ProjectSecret(credsPath)
+		d, err := m.ProjectSecret(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -496,7 +533,7 @@ func TestStructuredProjection(t *testing.T) {
 			t.Logf("%s:%s", filename, string(secrets))
 		}
 
-		yamlString, err := m.ProjectSecretAsYAMLString(credsPath)
+		yamlString, err := m.ProjectSecretAsYAMLString(credsFS)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -520,9 +557,254 @@ func TestStructuredProjection(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		_, err = m.ProjectSecret(credsPath)
+		_, err = m.ProjectSecret(credsFS)
 		if err == nil {
 			t.Fatalf("expected projecting %s (%s) would result in error, but didnt get an error", test, path)
 		}
 	}
 }
+
+func TestProvenanceAnnotation(t *testing.T) {
+	test := "structured-json-1"
+	config := getTestConfig()
+	config.addProvenanceAnnotations = true
+	data, err := ioutil.ReadFile(testManifests[test])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadFromYamlBytes(data, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := m.ProjectSecret(credsFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotation, ok := secret.Annotations[config.AnnotationProvenanceKey()]
+	if !ok {
+		t.Fatalf("expected Secret to be annotated with %s, but got %v", config.AnnotationProvenanceKey(), secret.Annotations)
+	}
+	provenance := map[string]types.Provenance{}
+	if err = json.Unmarshal([]byte(annotation), &provenance); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := provenance["secrets.json"]
+	if !ok {
+		t.Fatalf("expected provenance for secrets.json, but got %s", annotation)
+	}
+	if p.Repo != "production" || p.Path != "object1.json" || strings.Join(p.JSONPaths, ",") != "$.listroot,$.nesting.float,$.nesting.key1,$.nesting.list" {
+		t.Errorf("unexpected provenance for secrets.json: %+v", p)
+	}
+	for _, v := range secret.Data {
+		if strings.Contains(annotation, string(v)) {
+			t.Errorf("provenance annotation %s leaks secret value %s", annotation, v)
+		}
+	}
+}
+
+func TestNamespacesFanOut(t *testing.T) {
+	config := getTestConfig()
+	config.knownNamespaces = []string{"web-1", "web-2", "db"}
+	raw := `name: fanned-out
+namespaces:
+- web-*
+- api
+repo: production
+data:
+- name: raw-file
+  source:
+    raw: raw1.txt
+`
+	m, err := LoadFromYamlBytes([]byte(raw), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"api", "web-1", "web-2"}
+	if !reflect.DeepEqual(m.GetNamespaces(), expected) {
+		t.Errorf("expected namespaces %v, got %v", expected, m.GetNamespaces())
+	}
+	if _, err := m.ProjectSecret(credsFS); err != types.ErrMultipleNamespaces {
+		t.Errorf("expected %v projecting a single Secret, got %v", types.ErrMultipleNamespaces, err)
+	}
+	secrets, err := m.ProjectSecrets(credsFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != len(expected) {
+		t.Fatalf("expected %d Secrets, got %d", len(expected), len(secrets))
+	}
+	for i, s := range secrets {
+		if s.Namespace != expected[i] || s.Name != "fanned-out" {
+			t.Errorf("expected Secret %s/fanned-out, got %s/%s", expected[i], s.Namespace, s.Name)
+		}
+		if !reflect.DeepEqual(s.Data, secrets[0].Data) {
+			t.Errorf("expected every Secret to have the same data")
+		}
+	}
+
+	if _, err := LoadFromYamlBytes([]byte("namespace: web\n"+raw), &config); err != types.ErrNamespaceAndNamespaces {
+		t.Errorf("expected %v, got %v", types.ErrNamespaceAndNamespaces, err)
+	}
+	config.knownNamespaces = nil
+	if _, err := LoadFromYamlBytes([]byte(raw), &config); err != types.ErrNoKnownNamespaces {
+		t.Errorf("expected %v, got %v", types.ErrNoKnownNamespaces, err)
+	}
+}
+
+func TestEncryptionBoundToNamespace(t *testing.T) {
+	config := getTestConfig()
+	config.knownNamespaces = []string{"web-1", "web-2"}
+	raw := `name: fanned-out
+namespaces:
+- web-*
+repo: production
+encryption:
+  module: cbc
+data:
+- name: raw-file
+  encrypt: true
+  source:
+    raw: raw1.txt
+`
+	m, err := LoadFromYamlBytes([]byte(raw), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := m.ProjectSecrets(credsFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := encryption.NewModuleFromEncryptionConfig(testEncryptionConfigCbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range secrets {
+		other := secrets[1-i]
+		if _, err := e.Decrypt(s.Data["raw-file"], aad.Context{Namespace: s.Namespace, Name: s.Name, Key: "raw-file"}); err != nil {
+			t.Errorf("unable to decrypt raw-file in %s: %s", s.Namespace, err.Error())
+		}
+		if _, err := e.Decrypt(s.Data["raw-file"], aad.Context{Namespace: other.Namespace, Name: s.Name, Key: "raw-file"}); err == nil {
+			t.Errorf("raw-file encrypted for %s should not decrypt in %s", s.Namespace, other.Namespace)
+		}
+	}
+}
+
+func TestWrappedDecryptionKeys(t *testing.T) {
+	config := getTestConfig()
+	config.credsKeyDecryptionKeyFile = credsKeyDecryptionKey
+	raw := `name: wrapped
+namespace: web
+repo: production
+encryption:
+  module: cbc
+  include_decryption_keys: true
+data:
+- name: raw-file
+  encrypt: true
+  source:
+    raw: raw1.txt
+`
+	m, err := LoadFromYamlBytes([]byte(raw), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := m.ProjectSecret(credsFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(secret.Data["keys_1.json"]), "ell0_OliV3r!") {
+		t.Fatalf("keys_1.json should not hold the plaintext password: %s", secret.Data["keys_1.json"])
+	}
+
+	// a holder of the KEK unwraps the key, and decrypts with it
+	js := unwrapKeyJSON(t, secret.Data["keys_1.json"])
+	e, err := cbc.New(conf.Encryption{Module: "cbc", Params: map[string]string{}}, strings.NewReader(js), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := e.Decrypt(secret.Data["raw-file"], aad.Context{Namespace: "web", Name: "wrapped", Key: "raw-file"})
+	if err != nil {
+		t.Fatalf("unable to decrypt raw-file with the unwrapped key: %s", err.Error())
+	}
+	expected, err := credsFS.ReadFile("raw1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d) != string(expected) {
+		t.Errorf("expected raw-file to decrypt to %q, got %q", expected, d)
+	}
+}
+
+// unwrapKeyJSON unwraps a keys_N.json item with the credsKeyDecryptionKey KEK, returning the JSON of the key
+func unwrapKeyJSON(t *testing.T, raw []byte) string {
+	w := cbc.WrappedKey{}
+	if err := json.Unmarshal(raw, &w); err != nil {
+		t.Fatal(err)
+	}
+	kek, err := os.Open(credsKeyDecryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kek.Close()
+	unwrapper, err := cbc.New(conf.Encryption{Module: "cbc", Params: map[string]string{}}, strings.NewReader(`{"password":"unused"}`), kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := unwrapper.UnwrapKey(&w)
+	if err != nil {
+		t.Fatalf("unable to unwrap %s: %s", raw, err.Error())
+	}
+	js, err := json.Marshal(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+func TestLabelsAndAnnotations(t *testing.T) {
+	config := getTestConfig()
+	config.addProvenanceAnnotations = true
+	raw := `name: labelled
+namespace: web
+repo: production
+labels:
+  app: web
+annotations:
+  reloader.stakater.com/match: "true"
+data:
+- name: raw-file
+  source:
+    raw: raw1.txt
+`
+	m, err := LoadFromYamlBytes([]byte(raw), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := m.ProjectSecret(credsFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := map[string]string{
+		"app":                    "web",
+		config.LabelManagedKey(): "true",
+		config.LabelVersionKey(): config.Generation(),
+	}
+	if !reflect.DeepEqual(secret.Labels, expectedLabels) {
+		t.Errorf("expected labels %v, got %v", expectedLabels, secret.Labels)
+	}
+	if secret.Annotations["reloader.stakater.com/match"] != "true" || secret.Annotations[config.AnnotationProvenanceKey()] == "" {
+		t.Errorf("expected the mapping's annotations alongside provenance, got %v", secret.Annotations)
+	}
+
+	for _, bad := range []string{
+		"labels:\n  test/managed: \"false\"\n",
+		"labels:\n  test/tumblr-version: \"1\"\n",
+		"annotations:\n  test/provenance: \"{}\"\n",
+		"labels:\n  \"not a key\": x\n",
+		"labels:\n  app: \"not a value\"\n",
+	} {
+		if _, err := LoadFromYamlBytes([]byte("name: bad\nnamespace: web\nrepo: production\ndata: []\n"+bad), &config); err == nil {
+			t.Errorf("expected an error loading a mapping with %s", bad)
+		}
+	}
+}
@@ -2,13 +2,15 @@ package v1
 
 import (
 	"fmt"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
 )
 
 // Secret ...
 type Secret struct {
-	Name    string     `json:"name",yaml:"name"`
-	Encrypt bool       `json:"encrypt",yaml:"encrypt"`
-	Source  DataSource `json:"source",yaml:"source"`
+	Name    string     `json:"name" yaml:"name"`
+	Encrypt bool       `json:"encrypt,omitempty" yaml:"encrypt,omitempty"`
+	Source  DataSource `json:"source" yaml:"source"`
 }
 
 func (s *Secret) String() string {
@@ -16,6 +18,6 @@ func (s *Secret) String() string {
 }
 
 // Project returns the []byte of a projected secret and all its datasources
-func (s *Secret) Project(credsPath string) ([]byte, error) {
-	return s.Source.Project(credsPath)
+func (s *Secret) Project(fsys creds.FS) ([]byte, error) {
+	return s.Source.Project(fsys)
 }
@@ -0,0 +1,34 @@
+package v2
+
+import (
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+)
+
+// ConvertFromV1 returns the v2 equivalent of a v1 ProjectionMapping. The result projects
+// an identical Secret.
+func ConvertFromV1(in *v1.ProjectionMapping) *ProjectionMapping {
+	out := &ProjectionMapping{
+		APIVersion:  types.APIVersionV2,
+		Kind:        types.ProjectionMappingKind,
+		Name:        in.Name,
+		Namespace:   in.Namespace,
+		Namespaces:  in.Namespaces,
+		Repo:        in.Repo,
+		Labels:      in.Labels,
+		Annotations: in.Annotations,
+		Data:        make([]Secret, len(in.Data)),
+	}
+	if in.Encryption.Module != "" {
+		e := in.Encryption
+		out.Encryption = &e
+	}
+	for i, s := range in.Data {
+		out.Data[i] = Secret{
+			Name:    s.Name,
+			Encrypt: s.Encrypt,
+			Source:  s.Source,
+		}
+	}
+	return out
+}
@@ -0,0 +1,304 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/types"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+	"gopkg.in/yaml.v2"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// ErrWrongAPIVersion is returned when a document with another apiVersion is loaded as v2
+	ErrWrongAPIVersion = fmt.Errorf("projection mapping apiVersion must be %s", types.APIVersionV2)
+	// ErrWrongKind is returned when a document is not a projection mapping
+	ErrWrongKind = fmt.Errorf("projection mapping kind must be %s", types.ProjectionMappingKind)
+	// ErrDuplicateDataItem is returned when two data items project to the same key
+	ErrDuplicateDataItem = fmt.Errorf("data item names must be unique")
+)
+
+// ProjectionMapping is a v2 projection mapping. On top of v1, it sets the Secret's type,
+// labels and annotations, and lets each data item choose its own encryption.
+type ProjectionMapping struct {
+	APIVersion string `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string `json:"kind" yaml:"kind"`
+	Name       string `json:"name" yaml:"name"`
+	Namespace  string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Namespaces fans the Secret out to many namespaces, instead of Namespace. Entries may be globs
+	// matched against the known namespaces.
+	Namespaces []string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	Repo       string   `json:"repo" yaml:"repo"`
+	// Type is the type of the projected Secret, Opaque if unset
+	Type        k8sv1.SecretType  `json:"type,omitempty" yaml:"type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	// Encryption is used by data items that set encrypt, but have no encryption of their own
+	Encryption *conf.Encryption `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	Data       []Secret         `json:"data" yaml:"data"`
+
+	crypter encryption.Module
+	c       conf.Config
+	// namespaces are the resolved Namespaces
+	namespaces []string
+}
+
+// LoadFromYamlBytes parses a v2 ProjectionMapping, and sets up its encryption modules
+func LoadFromYamlBytes(raw []byte, cfg conf.Config) (types.ProjectionMapping, error) {
+	m, err := ParseYamlBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	m.c = cfg
+	if m.namespaces, err = types.ResolveNamespaces(m.Namespace, m.Namespaces, cfg.KnownNamespaces()); err != nil {
+		return nil, err
+	}
+	if err = types.ValidateLabels(m.Labels, cfg.LabelVersionKey(), cfg.LabelManagedKey()); err != nil {
+		return nil, err
+	}
+	if err = types.ValidateAnnotations(m.Annotations, cfg.AnnotationProvenanceKey()); err != nil {
+		return nil, err
+	}
+	if m.Encryption != nil {
+		m.crypter, err = newCrypter(m.Encryption, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range m.Data {
+		if m.Data[i].Encryption == nil {
+			continue
+		}
+		m.Data[i].crypter, err = newCrypter(m.Data[i].Encryption, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption for data item %s: %s", m.Data[i].Name, err.Error())
+		}
+	}
+	return m, nil
+}
+
+// ParseYamlBytes parses and validates a v2 ProjectionMapping, without binding it to a Config
+func ParseYamlBytes(raw []byte) (*ProjectionMapping, error) {
+	var m ProjectionMapping
+	err := yaml.UnmarshalStrict(raw, &m)
+	if err != nil {
+		return nil, err
+	}
+	if m.APIVersion != types.APIVersionV2 {
+		return nil, ErrWrongAPIVersion
+	}
+	if m.Kind != types.ProjectionMappingKind {
+		return nil, ErrWrongKind
+	}
+	names := map[string]bool{}
+	for _, s := range m.Data {
+		if names[s.Name] {
+			return nil, fmt.Errorf("%s: %s", ErrDuplicateDataItem.Error(), s.Name)
+		}
+		names[s.Name] = true
+		if err := s.Source.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid source for data item %s: %s", s.Name, err.Error())
+		}
+	}
+	return &m, nil
+}
+
+// newCrypter sets up the encryption module for e, merging in the key files from cfg
+// when the projection mapping doesnt name its own
+func newCrypter(e *conf.Encryption, cfg conf.Config) (encryption.Module, error) {
+	ec := *e
+	if ec.CredsKeysFilePath == "" {
+		ec.CredsKeysFilePath = cfg.CredsEncryptionKeyFile()
+	}
+	if ec.KeysDecrypterFilePath == "" {
+		ec.KeysDecrypterFilePath = cfg.CredsKeyDecryptionKeyFile()
+	}
+	return encryption.NewModuleFromEncryptionConfig(ec)
+}
+
+// ProjectSecretAsYAMLString will take a ProjectionMapping and return the k8s secret resource
+// as a YAML representation in string form
+func (m *ProjectionMapping) ProjectSecretAsYAMLString(fsys creds.FS) (string, error) {
+	return types.ProjectSecretAsYAMLString(m, fsys)
+}
+
+// ProjectSecret will take a ProjectionMapping and return the k8s secret resource,
+// reading its data sources from the creds repo fsys. Mappings that fan out to more
+// than one namespace must use ProjectSecrets.
+func (m *ProjectionMapping) ProjectSecret(fsys creds.FS) (*k8sv1.Secret, error) {
+	return types.ProjectSecret(m, fsys)
+}
+
+// ProjectSecrets projects the data sources once, and returns a copy of the k8s secret
+// resource for each namespace the ProjectionMapping targets. Encrypted items are
+// encrypted for each namespace, as they are bound to the namespace they are projected to
+func (m *ProjectionMapping) ProjectSecrets(fsys creds.FS) ([]*k8sv1.Secret, error) {
+	sec, err := m.projectSecret(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return types.FanOut(sec, m.GetNamespaces(), m.encryptData)
+}
+
+// crypterFor returns the encryption module for a data item: its own, or the mapping's
+func (m *ProjectionMapping) crypterFor(s Secret) encryption.Module {
+	if s.crypter != nil {
+		return s.crypter
+	}
+	return m.crypter
+}
+
+// encryptData encrypts the items of sec that request encryption, binding them to
+// the namespace, name and key they are projected to
+func (m *ProjectionMapping) encryptData(sec *k8sv1.Secret) error {
+	for _, s := range m.Data {
+		if !s.Encrypted() {
+			continue
+		}
+		ed, err := m.crypterFor(s).Encrypt(sec.Data[s.Name], aad.Context{
+			Namespace: sec.ObjectMeta.Namespace,
+			Name:      sec.ObjectMeta.Name,
+			Key:       s.Name,
+		})
+		if err != nil {
+			return err
+		}
+		sec.Data[s.Name] = ed
+	}
+	return nil
+}
+
+// projectSecret reads the data sources from the creds repo fsys into a k8s secret resource,
+// without a namespace, and with its data unencrypted
+func (m *ProjectionMapping) projectSecret(fsys creds.FS) (*k8sv1.Secret, error) {
+	data := map[string][]byte{}
+	// decryption keys are included once per encryption config that asks for them
+	includeKeysFrom := []encryption.Module{}
+	if m.crypter != nil && m.Encryption.IncludeDecryptionKeys {
+		includeKeysFrom = append(includeKeysFrom, m.crypter)
+	}
+	for _, s := range m.Data {
+		d, err := s.Project(fsys)
+		if err != nil {
+			return nil, err
+		}
+		data[s.Name] = d
+		if !s.Encrypted() {
+			continue
+		}
+		if s.crypter != nil && s.Encryption.IncludeDecryptionKeys {
+			includeKeysFrom = append(includeKeysFrom, s.crypter)
+		}
+		if m.crypterFor(s) == nil {
+			return nil, v1.ErrEncryptionRequestedButNoEncryptionConfigSpecified
+		}
+	}
+	n := 0
+	for _, crypter := range includeKeysFrom {
+		keys, err := crypter.DecryptionKeys()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			js, err := json.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+			n++
+			data[fmt.Sprintf("%s%d.json", v1.DecryptionKeysPrefix, n)] = js
+		}
+	}
+
+	secretType := m.Type
+	if secretType == "" {
+		secretType = k8sv1.SecretTypeOpaque
+	}
+	sekrit := k8sv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        m.Name,
+			Labels:      types.CopyStringMap(m.Labels),
+			Annotations: types.CopyStringMap(m.Annotations),
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		Type: secretType,
+		Data: data,
+	}
+	if m.c.AddDeployLabels() {
+		if sekrit.ObjectMeta.Labels == nil {
+			sekrit.ObjectMeta.Labels = map[string]string{}
+		}
+		sekrit.ObjectMeta.Labels[m.c.LabelVersionKey()] = m.c.Generation()
+		sekrit.ObjectMeta.Labels[m.c.LabelManagedKey()] = "true"
+	}
+	if m.c.AddProvenanceAnnotations() {
+		provenance, err := types.NewProvenanceAnnotation(m.GetSourceReferences(), fsys)
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine provenance of %s: %s", m.Name, err.Error())
+		}
+		if sekrit.ObjectMeta.Annotations == nil {
+			sekrit.ObjectMeta.Annotations = map[string]string{}
+		}
+		sekrit.ObjectMeta.Annotations[m.c.AnnotationProvenanceKey()] = provenance
+	}
+	return &sekrit, nil
+}
+
+func (m *ProjectionMapping) String() string {
+	data := make([]string, len(m.Data))
+	for i, s := range m.Data {
+		data[i] = s.String()
+	}
+	return types.FormatMapping(m.Namespace, m.Namespaces, m.Name, m.Repo, data)
+}
+
+// GetEncryptionConfig is the mapping level encryption configuration for this projection
+func (m *ProjectionMapping) GetEncryptionConfig() conf.Encryption {
+	if m.Encryption == nil {
+		return conf.Encryption{}
+	}
+	return *m.Encryption
+}
+
+// GetNamespace is the namespace the ProjectionMapping is bound to, empty if it fans out to Namespaces
+func (m *ProjectionMapping) GetNamespace() string {
+	return m.Namespace
+}
+
+// GetNamespaces are the namespaces the ProjectionMapping is projected into, with any globs
+// resolved when it was loaded
+func (m *ProjectionMapping) GetNamespaces() []string {
+	return types.Namespaces(m.namespaces, m.Namespace, m.Namespaces)
+}
+
+// GetName is the name of a ProjectionMapping
+func (m *ProjectionMapping) GetName() string {
+	return m.Name
+}
+
+// GetRepo returns the creds source repository for this ProjectionMapping
+func (m *ProjectionMapping) GetRepo() string {
+	return m.Repo
+}
+
+// GetSourceReferences returns the creds files each data item is projected from
+func (m *ProjectionMapping) GetSourceReferences() []types.SourceReference {
+	refs := make([]types.SourceReference, len(m.Data))
+	for i, s := range m.Data {
+		refs[i] = types.SourceReference{
+			Key:       s.Name,
+			Repo:      m.Repo,
+			Path:      s.Source.Path(),
+			JSONPaths: s.Source.Selectors(),
+		}
+	}
+	return refs
+}
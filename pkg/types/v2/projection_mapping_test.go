@@ -0,0 +1,203 @@
+package v2
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	_ "github.com/tumblr/k8s-secret-projector/internal/pkg/testing" // hack to make test fixtures non-relative
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/aad"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+const (
+	credsEncryptionKey    = "test/fixtures/files/encryption-cbc-key.json"
+	credsKeyDecryptionKey = "test/fixtures/files/aes_params_test_3.json"
+
+	testMappingV2 = `apiVersion: secret-projector.tumblr.com/v2
+kind: ProjectionMapping
+name: tls
+namespace: web
+repo: production
+type: kubernetes.io/tls
+labels:
+  app: web
+annotations:
+  owner: web-team
+encryption:
+  module: cbc
+data:
+- name: tls.crt
+  source:
+    raw: raw1.txt
+- name: tls.key
+  encrypt: true
+  source:
+    json: object1.json
+    jsonpath: $.secret
+- name: other.key
+  encryption:
+    module: cbc
+    include_decryption_keys: true
+  source:
+    json: object1.json
+    jsonpath: $.nesting.key1
+`
+)
+
+func newTestConfig(t *testing.T) conf.Config {
+	c, err := conf.LoadConfigFromArgs([]string{os.Args[0],
+		"-creds-repo=production=test/fixtures/files",
+		"-manifests=test/fixtures/manifests",
+		"-creds-encryption-key=" + credsEncryptionKey,
+		"-creds-key-decryption-key=" + credsKeyDecryptionKey,
+		"-generation=42",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestFS(t *testing.T) creds.FS {
+	fsys, err := creds.NewDir("test/fixtures/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func TestParseRequiresAPIVersionAndKind(t *testing.T) {
+	for raw, expected := range map[string]error{
+		"name: x\n": ErrWrongAPIVersion,
+		"apiVersion: secret-projector.tumblr.com/v1\nkind: ProjectionMapping\n": ErrWrongAPIVersion,
+		"apiVersion: secret-projector.tumblr.com/v2\nkind: Secret\n":            ErrWrongKind,
+	} {
+		if _, err := ParseYamlBytes([]byte(raw)); err != expected {
+			t.Errorf("expected %v parsing %q, got %v", expected, raw, err)
+		}
+	}
+}
+
+func TestParseRejectsDuplicateDataItems(t *testing.T) {
+	raw := `apiVersion: secret-projector.tumblr.com/v2
+kind: ProjectionMapping
+name: x
+namespace: y
+repo: production
+data:
+- name: a
+  source:
+    raw: raw1.txt
+- name: a
+  source:
+    raw: raw1.txt
+`
+	if _, err := ParseYamlBytes([]byte(raw)); err == nil {
+		t.Fatal("expected an error for duplicate data items")
+	}
+}
+
+func TestProjectSecretV2(t *testing.T) {
+	c := newTestConfig(t)
+	m, err := LoadFromYamlBytes([]byte(testMappingV2), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec, err := m.ProjectSecret(newTestFS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sec.Type != k8sv1.SecretTypeTLS {
+		t.Errorf("expected type %s, got %s", k8sv1.SecretTypeTLS, sec.Type)
+	}
+	expectedLabels := map[string]string{
+		"app":               "web",
+		c.LabelManagedKey(): "true",
+		c.LabelVersionKey(): "42",
+	}
+	if !reflect.DeepEqual(sec.Labels, expectedLabels) {
+		t.Errorf("expected labels %v, got %v", expectedLabels, sec.Labels)
+	}
+	if sec.Annotations["owner"] != "web-team" {
+		t.Errorf("expected owner annotation, got %v", sec.Annotations)
+	}
+
+	raw, err := ioutil.ReadFile("test/fixtures/files/raw1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sec.Data["tls.crt"]) != string(raw) {
+		t.Errorf("expected tls.crt to be projected unencrypted")
+	}
+	pm := m.(*ProjectionMapping)
+	for name, expected := range map[string]string{
+		"tls.key":   "paSsw0rd!",
+		"other.key": "foo",
+	} {
+		crypter := pm.crypter
+		for _, s := range pm.Data {
+			if s.Name == name && s.crypter != nil {
+				crypter = s.crypter
+			}
+		}
+		d, err := crypter.Decrypt(sec.Data[name], aad.Context{Namespace: sec.Namespace, Name: sec.Name, Key: name})
+		if err != nil {
+			t.Fatalf("unable to decrypt %s: %s", name, err.Error())
+		}
+		if string(d) != expected {
+			t.Errorf("expected %s to decrypt to %q, got %q", name, expected, string(d))
+		}
+	}
+	// only other.key asked for its decryption keys
+	if _, ok := sec.Data[v1.DecryptionKeysPrefix+"1.json"]; !ok {
+		t.Errorf("expected decryption keys to be included")
+	}
+	if _, ok := sec.Data[v1.DecryptionKeysPrefix+"2.json"]; ok {
+		t.Errorf("expected only one set of decryption keys to be included")
+	}
+}
+
+func TestConvertFromV1ProjectsTheSameSecret(t *testing.T) {
+	c := newTestConfig(t)
+	fsys := newTestFS(t)
+	raw, err := ioutil.ReadFile("test/fixtures/manifests/manifest_1.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, err := v1.LoadFromYamlBytes(raw, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := v1.ParseYamlBytes(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2 := ConvertFromV1(parsed)
+	m2.c = c
+	if m1.String() != m2.String() {
+		t.Errorf("expected %s, got %s", m1.String(), m2.String())
+	}
+	s1, err := m1.ProjectSecret(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := m2.ProjectSecret(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s1, s2) {
+		t.Errorf("expected converted mapping to project %v, got %v", s1, s2)
+	}
+}
+
+func TestConvertFromV1KeepsNamespaces(t *testing.T) {
+	m := ConvertFromV1(&v1.ProjectionMapping{Name: "app", Namespaces: []string{"web", "api"}, Repo: "production"})
+	if !reflect.DeepEqual(m.Namespaces, []string{"web", "api"}) || m.Namespace != "" {
+		t.Errorf("expected the converted mapping to fan out to web and api, got %q and %v", m.Namespace, m.Namespaces)
+	}
+}
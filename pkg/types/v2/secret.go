@@ -0,0 +1,35 @@
+package v2
+
+import (
+	"fmt"
+
+	"github.com/tumblr/k8s-secret-projector/pkg/conf"
+	"github.com/tumblr/k8s-secret-projector/pkg/creds"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
+	"github.com/tumblr/k8s-secret-projector/pkg/types/v1"
+)
+
+// Secret is a single data item of a v2 ProjectionMapping. Sources are the same as v1.
+type Secret struct {
+	Name    string `json:"name" yaml:"name"`
+	Encrypt bool   `json:"encrypt,omitempty" yaml:"encrypt,omitempty"`
+	// Encryption overrides the ProjectionMapping's encryption config for this item, and implies Encrypt
+	Encryption *conf.Encryption `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	Source     v1.DataSource    `json:"source" yaml:"source"`
+
+	crypter encryption.Module
+}
+
+func (s *Secret) String() string {
+	return fmt.Sprintf("%s:%s", s.Name, s.Source.String())
+}
+
+// Encrypted returns true if the item is encrypted, either explicitly or by its own encryption config
+func (s *Secret) Encrypted() bool {
+	return s.Encrypt || s.Encryption != nil
+}
+
+// Project returns the []byte of a projected secret and all its datasources
+func (s *Secret) Project(fsys creds.FS) ([]byte, error) {
+	return s.Source.Project(fsys)
+}
@@ -1,17 +1,21 @@
 package main // import github.com/tumblr/k8s-secret-projector
 
 import (
-	"C"
 	"io"
+	"log"
 
 	"github.com/tumblr/k8s-secret-projector/pkg/conf"
-	"github.com/tumblr/k8s-secret-projector/pkg/encryption"
 	"github.com/tumblr/k8s-secret-projector/pkg/encryption/cbc"
+	"github.com/tumblr/k8s-secret-projector/pkg/encryption/plugin"
 )
 
-// New creates a new encryption module that we use to return an encryption.Module interface
-func New(c conf.Encryption, credsKeyReader io.Reader, _ io.Reader) (encryption.Module, error) {
-	return cbc.New(c, credsKeyReader)
+// newModule creates the cbc encryption module served by this plugin
+func newModule(c conf.Encryption, credsKeyReader io.Reader, keysDecrypterReader io.Reader) (plugin.Module, error) {
+	return cbc.New(c, credsKeyReader, keysDecrypterReader)
 }
 
-func main() {}
+func main() {
+	if err := plugin.Serve(newModule); err != nil {
+		log.Fatal(err)
+	}
+}